@@ -0,0 +1,432 @@
+// Package archive works with tar.gz, tar.zst, tar.xz, tar.bz2, zip and plain
+// tar archives without the caller having to know which one it is holding.
+//
+// The format of an existing archive is detected from its magic bytes, so a
+// mislabeled download is still handled by the right backend. When creating
+// an archive, the format is chosen from the extension of the output name.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+	"github.com/qiuzhanghua/common/tar"
+	"github.com/qiuzhanghua/common/tbz2"
+	"github.com/qiuzhanghua/common/tgz"
+	"github.com/qiuzhanghua/common/txz"
+	"github.com/qiuzhanghua/common/tz"
+	"github.com/qiuzhanghua/common/tzst"
+)
+
+// Format is an archive format Detect recognizes.
+type Format int
+
+const (
+	Unknown Format = iota
+	TarGz
+	TarZst
+	Zip
+	Tar
+	TarXz
+	TarBz2
+)
+
+func (f Format) String() string {
+	switch f {
+	case TarGz:
+		return "tar.gz"
+	case TarZst:
+		return "tar.zst"
+	case Zip:
+		return "zip"
+	case Tar:
+		return "tar"
+	case TarXz:
+		return "tar.xz"
+	case TarBz2:
+		return "tar.bz2"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrUnknownFormat is returned for an archive whose format is not recognized.
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+// ErrReadOnlyFormat is returned when asked to write a tar.bz2 archive,
+// which can only be read.
+var ErrReadOnlyFormat = errors.New("archive format can only be read")
+
+const (
+	blockSize   = 512
+	magicOffset = 257
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	bzip2Magic = []byte("BZh")
+	zipMagics  = [][]byte{
+		[]byte("PK\x03\x04"),
+		[]byte("PK\x05\x06"), // empty archive
+		[]byte("PK\x07\x08"), // spanned archive
+	}
+	ustarMagic = []byte("ustar")
+)
+
+// ErrInsecurePath is returned by Extract for an entry, symlink or hard link
+// that would land outside dest.
+var ErrInsecurePath = archiver.ErrInsecurePath
+
+// ErrDestinationNotEmpty is returned by Extract with WithAtomic when the
+// destination exists and is not an empty directory.
+var ErrDestinationNotEmpty = archiver.ErrDestinationNotEmpty
+
+// Detect reads the first bytes of name and reports its archive format.
+func Detect(name string) (Format, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return Unknown, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	return DetectReader(file)
+}
+
+// DetectReader reads up to one tar block from r and reports its archive format.
+func DetectReader(r io.Reader) (Format, error) {
+	buf := make([]byte, blockSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		log.Errorf("Error reading archive header: %v", err)
+		return Unknown, err
+	}
+	return DetectBytes(buf[:n]), nil
+}
+
+// DetectBytes reports the archive format of head, which should hold at
+// least the first 512 bytes of the archive for plain tar to be recognised.
+func DetectBytes(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return TarGz
+	case bytes.HasPrefix(head, zstdMagic):
+		return TarZst
+	case bytes.HasPrefix(head, xzMagic):
+		return TarXz
+	case bytes.HasPrefix(head, bzip2Magic):
+		return TarBz2
+	}
+	for _, magic := range zipMagics {
+		if bytes.HasPrefix(head, magic) {
+			return Zip
+		}
+	}
+	if len(head) >= magicOffset+len(ustarMagic) &&
+		bytes.Equal(head[magicOffset:magicOffset+len(ustarMagic)], ustarMagic) {
+		return Tar
+	}
+	// Pre-POSIX tar has no magic, only a header checksum
+	if len(head) >= blockSize && validTarChecksum(head[:blockSize]) {
+		return Tar
+	}
+	return Unknown
+}
+
+// validTarChecksum checks the checksum field of a tar header block, which
+// is the sum of all header bytes with the field itself counted as spaces.
+func validTarChecksum(block []byte) bool {
+	field := strings.Trim(string(block[148:156]), " \x00")
+	if field == "" {
+		return false
+	}
+	var stored int64
+	if _, err := fmt.Sscanf(field, "%o", &stored); err != nil {
+		return false
+	}
+	var sum int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		sum += int64(b)
+	}
+	return sum == stored
+}
+
+// FormatOf picks the archive format from the extension of name.
+func FormatOf(name string) Format {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return TarGz
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tar.zstd"),
+		strings.HasSuffix(lower, ".tzst"):
+		return TarZst
+	case strings.HasSuffix(lower, ".tar.xz"), strings.HasSuffix(lower, ".txz"):
+		return TarXz
+	case strings.HasSuffix(lower, ".tar.bz2"), strings.HasSuffix(lower, ".tbz2"),
+		strings.HasSuffix(lower, ".tbz"):
+		return TarBz2
+	case strings.HasSuffix(lower, ".zip"):
+		return Zip
+	case strings.HasSuffix(lower, ".tar"):
+		return Tar
+	default:
+		return Unknown
+	}
+}
+
+// Compress creates name from files in the format given by its extension.
+func Compress(name string, files ...string) error {
+	return CompressWithOptions(context.Background(), name, files)
+}
+
+// CompressWithOptions is Compress that stops once ctx is done, configured by opts.
+func CompressWithOptions(ctx context.Context, name string, files []string, opts ...Option) error {
+	switch format := FormatOf(name); format {
+	case TarGz:
+		return tgz.CompressWithOptions(ctx, name, files, opts...)
+	case TarZst:
+		return tzst.CompressWithOptions(ctx, name, files, opts...)
+	case Zip:
+		return tz.CompressWithOptions(ctx, name, files, opts...)
+	case TarXz:
+		return txz.CompressWithOptions(ctx, name, files, opts...)
+	case TarBz2:
+		log.Errorf("Error choosing format for %s: %v", name, ErrReadOnlyFormat)
+		return fmt.Errorf("%w: %s", ErrReadOnlyFormat, format)
+	case Tar:
+		return tar.CompressWithOptions(ctx, name, files, opts...)
+	default:
+		log.Errorf("Error choosing format for %s: %v", name, ErrUnknownFormat)
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+	}
+}
+
+// Extract extracts name into dest, whatever its format.
+func Extract(name, dest string, opts ...Option) error {
+	return ExtractContext(context.Background(), name, dest, opts...)
+}
+
+// ExtractContext is Extract that stops once ctx is done.
+func ExtractContext(ctx context.Context, name, dest string, opts ...Option) error {
+	format, err := detectFile(name)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case TarGz:
+		return tgz.ExtractContext(ctx, name, dest, opts...)
+	case TarZst:
+		return tzst.ExtractContext(ctx, name, dest, opts...)
+	case Zip:
+		return tz.ExtractContext(ctx, name, dest, opts...)
+	case TarXz:
+		return txz.ExtractContext(ctx, name, dest, opts...)
+	case TarBz2:
+		return tbz2.ExtractContext(ctx, name, dest, opts...)
+	default:
+		return tar.ExtractContext(ctx, name, dest, opts...)
+	}
+}
+
+// List lists the entries of name, whatever its format.
+func List(name string) ([]string, error) {
+	entries, err := ListEntries(name)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.Strings(entries), nil
+}
+
+// Entry describes one member of an archive.
+type Entry = archiver.Entry
+
+// ListEntries describes every member of name, whatever its format.
+func ListEntries(name string) ([]Entry, error) {
+	format, err := detectFile(name)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case TarGz:
+		return tgz.ListEntries(name)
+	case TarZst:
+		return tzst.ListEntries(name)
+	case Zip:
+		return tz.ListEntries(name)
+	case TarXz:
+		return txz.ListEntries(name)
+	case TarBz2:
+		return tbz2.ListEntries(name)
+	default:
+		return tar.ListEntries(name)
+	}
+}
+
+// Report is what Verify found; Problems is empty for a sound archive.
+type Report = archiver.Report
+
+// Problem is one thing Verify found wrong, with the entry it was found in.
+type Problem = archiver.Problem
+
+// Verify reads all of name without writing anything, whatever its format,
+// and reports what is corrupt in it.
+func Verify(name string, opts ...Option) (*Report, error) {
+	return VerifyContext(context.Background(), name, opts...)
+}
+
+// VerifyContext is Verify that stops once ctx is done.
+func VerifyContext(ctx context.Context, name string, opts ...Option) (*Report, error) {
+	format, err := detectFile(name)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case TarGz:
+		return tgz.VerifyContext(ctx, name, opts...)
+	case TarZst:
+		return tzst.VerifyContext(ctx, name, opts...)
+	case Zip:
+		return tz.VerifyContext(ctx, name, opts...)
+	case TarXz:
+		return txz.VerifyContext(ctx, name, opts...)
+	case TarBz2:
+		return tbz2.VerifyContext(ctx, name, opts...)
+	default:
+		return tar.VerifyContext(ctx, name, opts...)
+	}
+}
+
+// ManifestName is the entry WithManifest adds at the end of an archive.
+const ManifestName = archiver.ManifestName
+
+// Manifest lists the SHA-256, size and mode of every regular file in an
+// archive written with WithManifest.
+type Manifest = archiver.Manifest
+
+var (
+	// ErrNoManifest is returned for archives written without WithManifest.
+	ErrNoManifest = archiver.ErrNoManifest
+	// ErrManifestMismatch is wrapped by the errors of files that differ
+	// from the manifest.
+	ErrManifestMismatch = archiver.ErrManifestMismatch
+)
+
+// ReadManifest returns the manifest of name, whatever its format, or
+// ErrNoManifest.
+func ReadManifest(name string) (*Manifest, error) {
+	return archiver.LoadManifest(ReadFile(name, ManifestName))
+}
+
+// FS is a read-only fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS view
+// of an archive. Close it when done.
+type FS = archiver.FS
+
+// OpenFS opens name for use as an fs.FS, whatever its format.
+func OpenFS(name string) (*FS, error) {
+	format, err := detectFile(name)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case TarGz:
+		return tgz.OpenFS(name)
+	case TarZst:
+		return tzst.OpenFS(name)
+	case Zip:
+		return tz.OpenFS(name)
+	case TarXz:
+		return txz.OpenFS(name)
+	case TarBz2:
+		return tbz2.OpenFS(name)
+	default:
+		return tar.OpenFS(name)
+	}
+}
+
+// Open returns the content of the regular file entry in name, whatever its
+// format, following symlinks inside the archive.
+func Open(name, entry string) (io.ReadCloser, error) {
+	format, err := detectFile(name)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case TarGz:
+		return tgz.Open(name, entry)
+	case TarZst:
+		return tzst.Open(name, entry)
+	case Zip:
+		return tz.Open(name, entry)
+	case TarXz:
+		return txz.Open(name, entry)
+	case TarBz2:
+		return tbz2.Open(name, entry)
+	default:
+		return tar.Open(name, entry)
+	}
+}
+
+// ReadFile returns the content of the regular file entry in name, like Open.
+func ReadFile(name, entry string) ([]byte, error) {
+	rc, err := Open(name, entry)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rc io.ReadCloser) {
+		err := rc.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
+		}
+	}(rc)
+	return io.ReadAll(rc)
+}
+
+// FileIn reports whether filename is in the archive name, whatever its format.
+func FileIn(filename, name string) bool {
+	format, err := detectFile(name)
+	if err != nil {
+		return false
+	}
+	switch format {
+	case TarGz:
+		return tgz.FileIn(filename, name)
+	case TarZst:
+		return tzst.FileIn(filename, name)
+	case Zip:
+		return tz.FileIn(filename, name)
+	case TarXz:
+		return txz.FileIn(filename, name)
+	case TarBz2:
+		return tbz2.FileIn(filename, name)
+	default:
+		return tar.FileIn(filename, name)
+	}
+}
+
+func detectFile(name string) (Format, error) {
+	format, err := Detect(name)
+	if err != nil {
+		return Unknown, err
+	}
+	if format == Unknown {
+		log.Errorf("Error detecting format of %s: %v", name, ErrUnknownFormat)
+		return Unknown, fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+	}
+	return format, nil
+}
@@ -0,0 +1,595 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFormatOf(t *testing.T) {
+	cases := map[string]Format{
+		"jdk-21.tar.gz":  TarGz,
+		"jdk-21.TGZ":     TarGz,
+		"model.tar.zst":  TarZst,
+		"model.tzst":     TarZst,
+		"git.zip":        Zip,
+		"layer.tar":      Tar,
+		"rootfs.tar.xz":  TarXz,
+		"rootfs.TXZ":     TarXz,
+		"src.tar.bz2":    TarBz2,
+		"src.tbz":        TarBz2,
+		"readme.md":      Unknown,
+		"archive.tar.lz": Unknown,
+	}
+	for name, expected := range cases {
+		actual := FormatOf(name)
+		if expected != actual {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, expected, actual)
+		}
+	}
+}
+
+func TestDetectMislabeled(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.tar.gz", "a.tar.zst", "a.tar.xz", "a.zip", "a.tar"} {
+		created := filepath.Join(dir, name)
+		if err := Compress(created, src); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		// Rename so the extension no longer tells the truth
+		mislabeled := created + ".bin"
+		if err := os.Rename(created, mislabeled); err != nil {
+			t.Fatal(err)
+		}
+		expected := FormatOf(name)
+		actual, err := Detect(mislabeled)
+		if err != nil {
+			t.Errorf("error: %s", err)
+		}
+		if expected != actual {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, expected, actual)
+		}
+
+		out := filepath.Join(dir, "out-"+name)
+		if err := Extract(mislabeled, out); err != nil {
+			t.Errorf("error: %s", err)
+		}
+		data, err := os.ReadFile(filepath.Join(out, "src", "hello.txt"))
+		if err != nil || string(data) != "hello" {
+			t.Errorf("Test failed for %s, expected: 'hello', got:  '%s' (%v)", name, data, err)
+		}
+		if !FileIn("hello.txt", mislabeled) {
+			t.Errorf("Test failed for %s, hello.txt not found", name)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), make([]byte, 100000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.tar.gz", "a.tar.zst", "a.tar.xz", "a.zip", "a.tar"} {
+		var last Progress
+		created := filepath.Join(dir, name)
+		err := CompressWithOptions(context.Background(), created, []string{src},
+			WithProgress(func(p Progress) { last = p }))
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if last.Total != 100005 || last.Bytes != last.Total {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, 100005, last)
+		}
+
+		last = Progress{}
+		err = Extract(created, filepath.Join(dir, "out-"+name),
+			WithProgress(func(p Progress) { last = p }))
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if last.Entries != 4 || last.Bytes != 100005 {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, 100005, last)
+		}
+	}
+}
+
+func TestListHasNoBlankLines(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.tar.gz", "a.tar.zst", "a.tar.xz", "a.zip", "a.tar"} {
+		created := filepath.Join(dir, name)
+		if err := Compress(created, src); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		actual, err := List(created)
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if len(actual) != 2 || actual[0] == "" || actual[1] != "File: src/a.txt" {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, "[Dir: src/ File: src/a.txt]", actual)
+		}
+	}
+}
+
+func TestOpenFS(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "config.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.tar.gz", "a.tar.zst", "a.tar.xz", "a.zip", "a.tar"} {
+		created := filepath.Join(dir, name)
+		if err := Compress(created, src); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		fsys, err := OpenFS(created)
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if err := fstest.TestFS(fsys, "src/sub/config.json"); err != nil {
+			t.Errorf("Test failed for %s: %v", name, err)
+		}
+		data, err := fs.ReadFile(fsys, "src/sub/config.json")
+		if err != nil || string(data) != "{}" {
+			t.Errorf("Test failed for %s, expected: '{}', got:  '%s' (%v)", name, data, err)
+		}
+		if err := fsys.Close(); err != nil {
+			t.Errorf("error: %s", err)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "jdk-21")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "release"), []byte("JAVA_VERSION=21"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("release", filepath.Join(src, "current")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.tar.gz", "a.tar.zst", "a.tar.xz", "a.zip", "a.tar"} {
+		created := filepath.Join(dir, name)
+		if err := Compress(created, src); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		for _, entry := range []string{"jdk-21/release", "jdk-21/current"} {
+			data, err := ReadFile(created, entry)
+			if err != nil || string(data) != "JAVA_VERSION=21" {
+				t.Errorf("Test failed for %s in %s, expected: 'JAVA_VERSION=21', got:  '%s' (%v)", entry, name, data, err)
+			}
+		}
+		if _, err := ReadFile(created, "jdk-21/missing"); err == nil {
+			t.Errorf("Test failed for %s, expected an error for a missing entry", name)
+		}
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "tool"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "readme.md"), []byte("readme"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	epoch := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tar.xz", ".zip", ".tar"} {
+		build := func(name string) []byte {
+			archive := filepath.Join(dir, name+ext)
+			if err := CompressWithOptions(context.Background(), archive, []string{src}, WithSourceDateEpoch(epoch)); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			data, err := os.ReadFile(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		}
+		first := build("first")
+
+		// A later checkout: new times and a different umask
+		later := time.Now().Add(time.Hour)
+		if err := os.Chmod(filepath.Join(src, "readme.md"), 0664); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(src, "readme.md"), later, later); err != nil {
+			t.Fatal(err)
+		}
+		second := build("second")
+		if !bytes.Equal(first, second) {
+			t.Errorf("Test failed for %s, expected the same bytes for the same tree", ext)
+		}
+		if err := os.Chmod(filepath.Join(src, "readme.md"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ListEntries(filepath.Join(dir, "first.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Uid != 0 || entry.Uname != "" || entry.ModTime.After(epoch) {
+			t.Errorf("Test failed, %s is not normalized: %+v", entry.Name, entry)
+		}
+		if entry.Name == "src/bin/tool" && entry.Mode.Perm() != 0755 {
+			t.Errorf("Test failed, expected: '%v', got:  '%v'", fs.FileMode(0755), entry.Mode.Perm())
+		}
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "git"), []byte("git binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "bin", "git"), filepath.Join(src, "git-core")); err != nil {
+		t.Skip("no hard links here:", err)
+	}
+
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tar"} {
+		archive := filepath.Join(dir, "links"+ext)
+		if err := Compress(archive, src); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		entries, err := ListEntries(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links := 0
+		for _, entry := range entries {
+			if entry.Type == tar.TypeLink {
+				links++
+				if entry.Name != "src/git-core" || entry.Linkname != "src/bin/git" {
+					t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", ext, "src/git-core -> src/bin/git", entry)
+				}
+			}
+		}
+		if links != 1 {
+			t.Errorf("Test failed for %s, expected: '%v' links, got:  '%v'", ext, 1, links)
+		}
+
+		dest := filepath.Join(dir, "dest"+ext)
+		if err := Extract(archive, dest); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		first, err := os.Stat(filepath.Join(dest, "src", "bin", "git"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := os.Stat(filepath.Join(dest, "src", "git-core"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(first, second) {
+			t.Errorf("Test failed for %s, expected a hard link", ext)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("weights "), 20000)
+	for _, name := range []string{"a.bin", "b.bin"} {
+		if err := os.WriteFile(filepath.Join(src, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tar.xz", ".zip", ".tar"} {
+		archive := filepath.Join(dir, "good"+ext)
+		if err := Compress(archive, src); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		report, err := Verify(archive)
+		if err != nil || !report.OK() || report.Entries != 3 || report.Bytes != 2*int64(len(content)) {
+			t.Errorf("Test failed for %s, expected a sound archive, got: %+v (%v)", ext, report, err)
+		}
+
+		data, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		truncated := filepath.Join(dir, "truncated"+ext)
+		if err := os.WriteFile(truncated, data[:len(data)*2/3], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if report, err := Verify(truncated); err != nil || report.OK() {
+			t.Errorf("Test failed for %s, expected problems with a truncated archive, got: %+v (%v)", ext, report, err)
+		}
+	}
+
+	// A bad gzip trailer is only noticed after the tar has ended
+	data, err := os.ReadFile(filepath.Join(dir, "good.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xff
+	if err := os.WriteFile(filepath.Join(dir, "trailer.tar.gz"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Verify(filepath.Join(dir, "trailer.tar.gz"))
+	if err != nil || report.OK() || report.Problems[0].Entry != "" {
+		t.Errorf("Test failed, expected a problem with the trailer, got: %+v (%v)", report, err)
+	}
+}
+
+func TestVerifyZipEntries(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), bytes.Repeat([]byte(name), 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := filepath.Join(dir, "src.zip")
+	if err := Compress(archive, src); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name == "src/a.txt" {
+			offset, _ := f.DataOffset()
+			data[offset+int64(f.CompressedSize64)/2] ^= 0xff
+		}
+	}
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Verify(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Entry != "src/a.txt" || report.Entries != 3 {
+		t.Errorf("Test failed, expected one problem in src/a.txt, got: %+v", report)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), bytes.Repeat([]byte(name), 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tar.xz", ".zip", ".tar"} {
+		archive := filepath.Join(dir, "src"+ext)
+		if err := CompressWithOptions(context.Background(), archive, []string{src}, WithManifest()); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		manifest, err := ReadManifest(archive)
+		if err != nil || len(manifest.Files) != 2 || manifest.Files[0].Name != "src/a.txt" || manifest.Files[0].Size != 5000 {
+			t.Fatalf("Test failed for %s, expected: '%v', got:  '%+v' (%v)", ext, "src/a.txt", manifest, err)
+		}
+		if report, err := Verify(archive); err != nil || !report.OK() {
+			t.Errorf("Test failed for %s, expected a sound archive, got: %+v (%v)", ext, report, err)
+		}
+
+		dest := filepath.Join(dir, "out"+ext)
+		if err := Extract(archive, dest, WithStripComponents(1), WithCheckManifest()); err != nil {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", ext, nil, err)
+		}
+		report, err := manifest.Audit(dest, WithStripComponents(1))
+		if err != nil || !report.OK() || report.Entries != 2 {
+			t.Errorf("Test failed for %s, expected a clean audit, got: %+v (%v)", ext, report, err)
+		}
+		if err := os.WriteFile(filepath.Join(dest, "sub", "b.txt"), []byte("changed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		report, err = manifest.Audit(dest, WithStripComponents(1))
+		if err != nil || len(report.Problems) != 1 || !errors.Is(report.Err(), ErrManifestMismatch) {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%+v' (%v)", ext, ErrManifestMismatch, report, err)
+		}
+	}
+
+	// A manifest that does not match the content
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	manifest := `{"files":[{"name":"a.txt","size":1,"mode":420,"sha256":"00"}]}`
+	for name, content := range map[string]string{"a.txt": "x", ManifestName: manifest} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "tampered.tar")
+	if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Extract(archive, filepath.Join(dir, "tampered"), WithCheckManifest()); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrManifestMismatch, err)
+	}
+	if report, err := Verify(archive); err != nil || report.OK() {
+		t.Errorf("Test failed, expected a manifest problem, got: %+v (%v)", report, err)
+	}
+
+	if err := Compress(filepath.Join(dir, "plain.tar"), src); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := Extract(filepath.Join(dir, "plain.tar"), filepath.Join(dir, "plain"), WithCheckManifest()); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrNoManifest, err)
+	}
+	if _, err := ReadManifest(filepath.Join(dir, "plain.tar")); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrNoManifest, err)
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "sdk")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "tool"), bytes.Repeat([]byte("#!/bin/sh\n"), 500), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bin/tool", filepath.Join(src, "tool")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "bin", "tool"), filepath.Join(src, "bin", "alias")); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, name := range []string{"bin/tool", "bin/alias"} {
+		if err := os.Chtimes(filepath.Join(src, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(dir, "sdk.tar.gz")
+	if err := CompressWithOptions(context.Background(), archive, []string{src}, WithManifest()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	for _, chain := range [][]string{{".tar.zst"}, {".zip"}, {".tar"}, {".tar.xz"}, {".zip", ".tar.zst"}} {
+		from := archive
+		for _, ext := range chain {
+			to := filepath.Join(dir, "sdk"+chain[0]+"-"+ext[1:]+ext)
+			if err := Transcode(from, to); err != nil {
+				t.Fatalf("Test failed for %v, expected: '%v', got:  '%v'", chain, nil, err)
+			}
+			from = to
+		}
+		if report, err := Verify(from); err != nil || !report.OK() {
+			t.Errorf("Test failed for %v, expected a sound archive, got: %+v (%v)", chain, report, err)
+		}
+		dest := filepath.Join(dir, "out-"+filepath.Base(from))
+		if err := Extract(from, dest, WithCheckManifest()); err != nil {
+			t.Fatalf("Test failed for %v, expected: '%v', got:  '%v'", chain, nil, err)
+		}
+		info, err := os.Stat(filepath.Join(dest, "sdk", "bin", "alias"))
+		if err != nil || info.Mode().Perm() != 0755 || !info.ModTime().Equal(modTime) || info.Size() != 5000 {
+			t.Errorf("Test failed for %v, expected: '%v', got:  '%v' (%v)", chain, "-rwxr-xr-x 5000 "+modTime.String(), info, err)
+		}
+		if link, err := os.Readlink(filepath.Join(dest, "sdk", "tool")); link != "bin/tool" {
+			t.Errorf("Test failed for %v, expected: '%v', got:  '%v' (%v)", chain, "bin/tool", link, err)
+		}
+	}
+
+	if err := Transcode(archive, archive); err == nil {
+		t.Errorf("Test failed, expected an error transcoding onto the source, got:  '%v'", err)
+	}
+	if err := Transcode(archive, filepath.Join(dir, "sdk.rar")); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrUnknownFormat, err)
+	}
+	if err := Transcode(archive, filepath.Join(dir, "sdk.tar.bz2")); !errors.Is(err, ErrReadOnlyFormat) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrReadOnlyFormat, err)
+	}
+}
+
+func TestTarBz2(t *testing.T) {
+	bzip2, err := exec.LookPath("bzip2")
+	if err != nil {
+		t.Skip("bzip2 not found")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "src.tar")
+	if err := CompressWithOptions(context.Background(), archive, []string{src}, WithManifest()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if out, err := exec.Command(bzip2, archive).CombinedOutput(); err != nil {
+		t.Fatalf("error: %s %s", err, out)
+	}
+	archive += ".bz2"
+
+	format, err := Detect(archive)
+	if err != nil || format != TarBz2 {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", TarBz2, format, err)
+	}
+	if report, err := Verify(archive); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected a sound archive, got: %+v (%v)", report, err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := Extract(archive, out, WithCheckManifest()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "src", "hello.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Test failed, expected: 'hello', got:  '%s' (%v)", data, err)
+	}
+	if err := Transcode(archive, filepath.Join(dir, "src.tar.xz")); err != nil {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", nil, err)
+	}
+	if err := Compress(filepath.Join(dir, "new.tar.bz2"), src); !errors.Is(err, ErrReadOnlyFormat) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrReadOnlyFormat, err)
+	}
+}
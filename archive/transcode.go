@@ -0,0 +1,189 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+	"github.com/qiuzhanghua/common/tgz"
+	"github.com/qiuzhanghua/common/txz"
+	"github.com/qiuzhanghua/common/tzst"
+	"github.com/ulikunitz/xz"
+)
+
+// Transcode converts the archive src, whatever its format, into dst, in the
+// format given by its extension, e.g. a .tar.gz release into a .tar.zst.
+// tar.bz2 can be a source but not a target.
+// Entries are streamed from one to the other without extracting anything,
+// keeping the names, modes, times, symlinks, owners and PAX records the
+// target format can hold; a manifest written by WithManifest is kept as it
+// is. Hard links become copies in zip, which has none. opts configure the
+// writing of dst, like for CompressWithOptions, and progress is reported
+// against the size of src. dst is removed again if anything goes wrong.
+func Transcode(src, dst string, opts ...Option) error {
+	return TranscodeContext(context.Background(), src, dst, opts...)
+}
+
+// TranscodeContext is Transcode that stops once ctx is done.
+func TranscodeContext(ctx context.Context, src, dst string, opts ...Option) (err error) {
+	target := FormatOf(dst)
+	if target == Unknown {
+		log.Errorf("Error choosing format for %s: %v", dst, ErrUnknownFormat)
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, dst)
+	}
+	if target == TarBz2 {
+		log.Errorf("Error choosing format for %s: %v", dst, ErrReadOnlyFormat)
+		return fmt.Errorf("%w: %s", ErrReadOnlyFormat, target)
+	}
+	format, err := detectFile(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(in)
+	info, err := in.Stat()
+	if err != nil {
+		log.Errorf("Error stating file: %v", err)
+		return err
+	}
+	if out, err := os.Stat(dst); err == nil && os.SameFile(info, out) {
+		return fmt.Errorf("transcode %s: source and destination are the same file", src)
+	}
+	cfg := archiver.NewConfig(append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)...)
+	meter := cfg.Meter(-1)
+
+	source, closer, err := transcodeSource(ctx, format, in, info.Size(), meter, cfg)
+	if err != nil {
+		return err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		log.Errorf("Error creating archive: %v", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			if err := os.Remove(dst); err != nil {
+				log.Errorf("Error removing archive: %v", err)
+			}
+		}
+	}()
+
+	if target == Zip {
+		zipWriter, err := archiver.NewZipWriter(out, cfg)
+		if err != nil {
+			return err
+		}
+		if err := archiver.Transcode(ctx, source, archiver.ZipSink(zipWriter, cfg, out), meter); err != nil {
+			return err
+		}
+		if err := zipWriter.Close(); err != nil {
+			log.Errorf("Error closing zip: %v", err)
+			return err
+		}
+		return out.Close()
+	}
+
+	var stream io.WriteCloser = nopWriteCloser{out}
+	switch target {
+	case TarGz:
+		stream, err = tgz.NewWriter(out, opts...)
+	case TarZst:
+		stream, err = tzst.NewWriter(out, opts...)
+	case TarXz:
+		stream, err = txz.NewWriter(out, opts...)
+	}
+	if err != nil {
+		log.Errorf("Error creating compressor: %v", err)
+		return err
+	}
+	tarWriter := tar.NewWriter(stream)
+	if err := archiver.Transcode(ctx, source, archiver.TarSink(tarWriter, cfg), meter); err != nil {
+		_ = stream.Close()
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		log.Errorf("Error closing tar: %v", err)
+		_ = stream.Close()
+		return err
+	}
+	if err := stream.Close(); err != nil {
+		log.Errorf("Error closing compressor: %v", err)
+		return err
+	}
+	return out.Close()
+}
+
+// transcodeSource reads the entries of in, an archive in format.
+func transcodeSource(ctx context.Context, format Format, in *os.File, size int64, meter *archiver.Meter, cfg *archiver.Config) (archiver.Source, io.Closer, error) {
+	if format == Zip {
+		zipReader, err := archiver.NewZipReader(in, size)
+		if err != nil {
+			log.Errorf("Error opening archive: %v", err)
+			return nil, nil, err
+		}
+		return archiver.ZipSource(zipReader.File), noClose, nil
+	}
+
+	r := archiver.Reader(ctx, meter.Reader(in))
+	switch format {
+	case TarGz:
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			log.Errorf("Error reading gzip: %v", err)
+			return nil, nil, err
+		}
+		return archiver.TarSource(tar.NewReader(gzipReader)), gzipReader, nil
+	case TarZst:
+		zstdReader, err := zstd.NewReader(r, cfg.ZstdDecoder...)
+		if err != nil {
+			log.Errorf("Error creating zstd reader: %v", err)
+			return nil, nil, err
+		}
+		return archiver.TarSource(tar.NewReader(zstdReader)), archiver.CloserFunc(func() error {
+			zstdReader.Close()
+			return nil
+		}), nil
+	case TarXz:
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			log.Errorf("Error reading xz: %v", err)
+			return nil, nil, err
+		}
+		return archiver.TarSource(tar.NewReader(xzReader)), noClose, nil
+	case TarBz2:
+		return archiver.TarSource(tar.NewReader(bzip2.NewReader(r))), noClose, nil
+	}
+	return archiver.TarSource(tar.NewReader(r)), noClose, nil
+}
+
+var noClose = archiver.CloserFunc(func() error { return nil })
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
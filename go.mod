@@ -2,14 +2,16 @@ module github.com/qiuzhanghua/common
 
 go 1.25
 
-require github.com/labstack/gommon v0.4.2
-
-require github.com/klauspost/compress v1.18.3
+require (
+	github.com/klauspost/compress v1.18.3
+	github.com/labstack/gommon v0.4.2
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/sys v0.20.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/sys v0.20.0 // indirect
 )
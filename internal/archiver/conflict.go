@@ -0,0 +1,107 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// ErrConflict is returned by Extract with FailOnConflict for an entry whose
+// path is already taken at the destination.
+var ErrConflict = errors.New("destination path already exists")
+
+// Policy decides what Extract does when an entry's path is already taken
+// by something it did not write itself.
+type Policy int
+
+const (
+	// Overwrite replaces what is there.
+	Overwrite Policy = iota
+	// SkipExisting keeps what is there.
+	SkipExisting
+	// KeepNewer keeps what is there if it was modified after the entry.
+	KeepNewer
+	// FailOnConflict stops Extract with ErrConflict.
+	FailOnConflict
+	// Backup renames what is there by appending the backup suffix.
+	Backup
+)
+
+// DefaultBackupSuffix is what Backup appends unless told otherwise.
+const DefaultBackupSuffix = "~"
+
+// Changes lists what Extract did at the destination, by slash-separated
+// paths relative to it. Directories are only listed when created. Updated
+// and Removed are only filled in by WithSync, for files of which only the
+// mode changed and for what the archive does not have.
+type Changes struct {
+	Created  []string
+	Replaced []string
+	Skipped  []string
+	Updated  []string
+	Removed  []string
+}
+
+// claim clears the way for the entry modified at modTime to be written at
+// name, according to the policy, and reports whether to go ahead.
+func (x *Extractor) claim(name string, modTime time.Time) (bool, error) {
+	slashed := filepath.ToSlash(name)
+	info, err := x.root.Lstat(name)
+	if os.IsNotExist(err) {
+		x.track(name)
+		x.written[name] = true
+		x.changes.Created = append(x.changes.Created, slashed)
+		return true, nil
+	} else if err != nil {
+		log.Errorf("Error stating file: %v", err)
+		return false, err
+	}
+
+	policy := x.cfg.Policy
+	if x.written[name] {
+		// Written earlier by this extraction, so not the user's
+		policy = Overwrite
+	}
+	switch policy {
+	case SkipExisting:
+		x.skip(name)
+		return false, nil
+	case KeepNewer:
+		if info.ModTime().After(modTime) {
+			x.skip(name)
+			return false, nil
+		}
+	case FailOnConflict:
+		log.Errorf("Error extracting: %s already exists", name)
+		return false, fmt.Errorf("%w: %s", ErrConflict, slashed)
+	case Backup:
+		if err := x.root.Rename(name, name+x.cfg.BackupSuffix); err != nil {
+			log.Errorf("Error backing up existing file: %v", err)
+			return false, err
+		}
+		if x.kept != nil {
+			x.keep(name + x.cfg.BackupSuffix)
+		}
+	}
+	if policy != Backup {
+		if err := x.root.Remove(name); err != nil {
+			log.Errorf("Error removing existing file: %v", err)
+			return false, err
+		}
+	}
+	if !x.written[name] {
+		x.written[name] = true
+		x.changes.Replaced = append(x.changes.Replaced, slashed)
+	}
+	return true, nil
+}
+
+// skip records that the entry at name was left out for what is there.
+func (x *Extractor) skip(name string) {
+	x.skipped[name] = true
+	x.changes.Skipped = append(x.changes.Skipped, filepath.ToSlash(name))
+}
@@ -0,0 +1,32 @@
+// Package archiver holds the plumbing shared by the tgz, tzst and tz packages.
+package archiver
+
+import (
+	"context"
+	"io"
+)
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Reader wraps r so that every Read fails with the context error once ctx is done.
+func Reader(ctx context.Context, r io.Reader) io.Reader {
+	if ctx.Done() == nil {
+		return r
+	}
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+// Copy is io.Copy that stops between chunks once ctx is done.
+func Copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, Reader(ctx, src))
+}
@@ -0,0 +1,19 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCopyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	_, err := Copy(ctx, &buf, strings.NewReader("hello"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", context.Canceled, err)
+	}
+}
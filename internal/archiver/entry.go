@@ -0,0 +1,106 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// Entry describes one member of an archive.
+type Entry struct {
+	Name string
+	// Type is the tar type flag: tar.TypeReg, tar.TypeDir, tar.TypeSymlink,
+	// tar.TypeLink, tar.TypeChar, tar.TypeBlock or tar.TypeFifo.
+	// Zip members are mapped onto the same flags.
+	Type     byte
+	Size     int64
+	Mode     fs.FileMode // permission and type bits
+	ModTime  time.Time
+	Uid      int
+	Gid      int
+	Uname    string
+	Gname    string
+	Linkname string // target of a symlink or hard link
+	Devmajor int64
+	Devminor int64
+	Comment  string // zip entry comment, or PAX comment record
+}
+
+// EntryOf describes the member header stands for.
+func EntryOf(header *tar.Header) Entry {
+	return Entry{
+		Name:     header.Name,
+		Type:     header.Typeflag,
+		Size:     header.Size,
+		Mode:     header.FileInfo().Mode(),
+		ModTime:  header.ModTime,
+		Uid:      header.Uid,
+		Gid:      header.Gid,
+		Uname:    header.Uname,
+		Gname:    header.Gname,
+		Linkname: header.Linkname,
+		Devmajor: header.Devmajor,
+		Devminor: header.Devminor,
+		Comment:  header.PAXRecords["comment"],
+	}
+}
+
+// String formats e the way List always has, e.g. "File: a" or "Symlink: a -> b".
+func (e Entry) String() string {
+	switch e.Type {
+	case tar.TypeReg:
+		return fmt.Sprintf("File: %s", e.Name)
+	case tar.TypeDir:
+		return fmt.Sprintf("Dir: %s", e.Name)
+	case tar.TypeSymlink:
+		return fmt.Sprintf("Symlink: %s -> %s", e.Name, e.Linkname)
+	case tar.TypeLink:
+		return fmt.Sprintf("Link: %s -> %s", e.Name, e.Linkname)
+	case tar.TypeChar:
+		return fmt.Sprintf("Char: %s %d,%d", e.Name, e.Devmajor, e.Devminor)
+	case tar.TypeBlock:
+		return fmt.Sprintf("Block: %s %d,%d", e.Name, e.Devmajor, e.Devminor)
+	case tar.TypeFifo:
+		return fmt.Sprintf("Fifo: %s", e.Name)
+	default:
+		return fmt.Sprintf("Type %c: %s", e.Type, e.Name)
+	}
+}
+
+// ListEntries reads every member of src. PAX headers are not members and
+// are skipped. On error, the entries read so far are returned with it.
+func ListEntries(ctx context.Context, src Source) ([]Entry, error) {
+	result := make([]Entry, 0, 8)
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		header, _, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Error reading archive: %v", err)
+			return result, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader || header.Typeflag == tar.TypeXHeader {
+			log.Debugf("Skipping %s of PAX records: %s", header.Name, header.PAXRecords)
+			continue
+		}
+		result = append(result, EntryOf(header))
+	}
+	return result, nil
+}
+
+// Strings formats entries for List.
+func Strings(entries []Entry) []string {
+	result := make([]string, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.String())
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestListEntriesSpecialFiles(t *testing.T) {
+	tr := tarOf(t,
+		testEntry{header: tar.Header{Name: "a.txt", Typeflag: tar.TypeReg, Uid: 1000, Gid: 100}, body: "hello"},
+		testEntry{header: tar.Header{Name: "b.txt", Typeflag: tar.TypeLink, Linkname: "a.txt"}},
+		testEntry{header: tar.Header{Name: "null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3}},
+		testEntry{header: tar.Header{Name: "sda", Typeflag: tar.TypeBlock, Devmajor: 8}},
+		testEntry{header: tar.Header{Name: "pipe", Typeflag: tar.TypeFifo}},
+	)
+	entries, err := ListEntries(context.Background(), TarSource(tr))
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	expected := []string{"File: a.txt", "Link: b.txt -> a.txt", "Char: null 1,3", "Block: sda 8,0", "Fifo: pipe"}
+	actual := Strings(entries)
+	if len(actual) != len(expected) {
+		t.Fatalf("Test failed, expected: '%v', got:  '%v'", expected, actual)
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("Test failed, expected: '%v', got:  '%v'", expected[i], actual[i])
+		}
+	}
+	if entries[0].Size != 5 || entries[0].Uid != 1000 || entries[0].Gid != 100 {
+		t.Errorf("Test failed, got:  '%+v'", entries[0])
+	}
+}
+
+func TestListEntriesZipUnread(t *testing.T) {
+	// A method no reader is registered for, so opening the entry fails
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "a.bin", Method: 99, CompressedSize64: 5, UncompressedSize64: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ListEntries(context.Background(), ZipSource(zr.File))
+	if err != nil || len(entries) != 1 || entries[0].Name != "a.bin" {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", "a.bin", entries, err)
+	}
+	_, r, err := ZipSource(zr.File).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, zip.ErrAlgorithm) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", zip.ErrAlgorithm, err)
+	}
+}
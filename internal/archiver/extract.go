@@ -0,0 +1,613 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/util"
+)
+
+// ErrInsecurePath is returned for entries, symlinks and hard links
+// that would reach outside the destination directory.
+var ErrInsecurePath = errors.New("security violation: path escapes destination")
+
+// ErrLinkTargetExcluded is returned by Extract for a hard link that is
+// extracted while its target was left out, so has no content to link to.
+var ErrLinkTargetExcluded = errors.New("hard link target is not extracted")
+
+// ErrDestinationNotEmpty is returned by an atomic Extract whose destination
+// already holds something, which it would otherwise have to replace.
+var ErrDestinationNotEmpty = errors.New("destination is not empty")
+
+// Source yields the entries of an archive in order. Next returns io.EOF
+// after the last entry; the reader it returns is valid until the next call.
+type Source interface {
+	Next() (*tar.Header, io.Reader, error)
+}
+
+type tarSource struct {
+	tr *tar.Reader
+}
+
+func (s tarSource) Next() (*tar.Header, io.Reader, error) {
+	header, err := s.tr.Next()
+	return header, s.tr, err
+}
+
+// TarSource reads the entries of tr.
+func TarSource(tr *tar.Reader) Source {
+	return tarSource{tr: tr}
+}
+
+// Extractor writes archive entries below a destination directory.
+// Every file system access goes through an os.Root, so no entry can land
+// outside the destination, and the process working directory is never changed.
+type Extractor struct {
+	ctx   context.Context
+	cfg   *Config
+	meter *Meter
+	dest  string
+	root  *os.Root
+
+	// created lists the names this extraction created, for rollback.
+	created     []string
+	destCreated bool
+
+	// final is where dest is renamed to when an atomic extraction succeeds,
+	// or "" once it has been.
+	final string
+
+	// dirs waits for finish; owner is whether ownership is restored, with
+	// uids and gids caching the local ids of user and group names.
+	dirs  []dirMeta
+	owner bool
+	uids  map[string]int
+	gids  map[string]int
+
+	// sums hashes what is written, to check it against the manifest.
+	sums *manifestSums
+
+	// written holds the names this extraction wrote, which are not
+	// conflicts if written again, and skipped those the policy kept as
+	// they were; changes is what it did.
+	written map[string]bool
+	skipped map[string]bool
+	changes *Changes
+
+	// kept holds what the archive has, for WithSync to keep.
+	kept map[string]bool
+
+	// excluded holds the archive paths of the entries left out by
+	// WithInclude, WithExclude or WithRename, which hard links can't use.
+	excluded map[string]bool
+}
+
+// NewExtractor opens dest, creating it if needed. total is the content size
+// of all entries for progress reporting, or -1 if it is not known.
+func NewExtractor(ctx context.Context, dest string, cfg *Config, total int64) (*Extractor, error) {
+	dest, err := util.ExpandHome(dest)
+	if err != nil {
+		log.Errorf("Error expanding home dir: %v", err)
+		return nil, err
+	}
+	dest, err = util.AbsPath(dest)
+	if err != nil {
+		log.Errorf("Error getting absolute path: %v", err)
+		return nil, err
+	}
+	for _, pattern := range append(slices.Clone(cfg.Include), cfg.Exclude...) {
+		if !ValidPattern(pattern) {
+			log.Errorf("Error in pattern %q: %v", pattern, path.ErrBadPattern)
+			return nil, fmt.Errorf("%w: %q", path.ErrBadPattern, pattern)
+		}
+	}
+	x := &Extractor{ctx: ctx, cfg: cfg, meter: cfg.Meter(total), dest: dest, written: map[string]bool{}, skipped: map[string]bool{}, excluded: map[string]bool{}, changes: cfg.Changes}
+	if x.changes == nil {
+		x.changes = &Changes{}
+	}
+	if cfg.Sync {
+		x.kept = map[string]bool{}
+	}
+	if cfg.PreserveOwner && os.Geteuid() == 0 {
+		x.owner, x.uids, x.gids = true, map[string]int{}, map[string]int{}
+	}
+	if cfg.CheckManifest {
+		x.sums = newManifestSums()
+	}
+	if cfg.Atomic {
+		if err := x.stage(); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Lstat(dest); os.IsNotExist(err) {
+		x.destCreated = true
+	}
+	if err := os.MkdirAll(x.dest, 0755); err != nil {
+		log.Errorf("Error creating directory: %v", err)
+		return nil, err
+	}
+	x.root, err = os.OpenRoot(x.dest)
+	if err != nil {
+		log.Errorf("Error opening directory: %v", err)
+		return nil, err
+	}
+	return x, nil
+}
+
+// stage points the extraction at a new directory next to dest, on the
+// same file system, for commit to rename into place.
+func (x *Extractor) stage() error {
+	entries, err := os.ReadDir(x.dest)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error reading directory: %v", err)
+		return err
+	} else if len(entries) > 0 {
+		log.Errorf("Error extracting atomically: %s is not empty", x.dest)
+		return fmt.Errorf("%w: %s", ErrDestinationNotEmpty, x.dest)
+	}
+	parent := filepath.Dir(x.dest)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		log.Errorf("Error creating directory: %v", err)
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, "."+filepath.Base(x.dest)+".tmp-")
+	if err != nil {
+		log.Errorf("Error creating directory: %v", err)
+		return err
+	}
+	// MkdirTemp leaves it 0700, which would stay with dest
+	if err := os.Chmod(tmp, 0755); err != nil {
+		_ = os.Remove(tmp)
+		log.Errorf("Error setting directory mode: %v", err)
+		return err
+	}
+	x.final, x.dest, x.destCreated = x.dest, tmp, true
+	return nil
+}
+
+// commit renames the staged directory to the destination, which may only
+// be an empty directory by now.
+func (x *Extractor) commit() error {
+	if err := x.root.Close(); err != nil {
+		log.Errorf("Error closing directory: %v", err)
+		return err
+	}
+	if err := os.Remove(x.final); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error replacing destination: %v", err)
+		return err
+	}
+	if err := os.Rename(x.dest, x.final); err != nil {
+		log.Errorf("Error renaming into place: %v", err)
+		return err
+	}
+	x.dest, x.final = x.final, ""
+	return nil
+}
+
+// Close releases the destination directory. An atomic extraction that did
+// not succeed is removed, also when Close is deferred past a panic.
+func (x *Extractor) Close() error {
+	err := x.root.Close()
+	if x.final != "" {
+		if err := os.RemoveAll(x.dest); err != nil {
+			log.Errorf("Error removing staging directory: %v", err)
+		}
+	}
+	return err
+}
+
+// Reader wraps the raw archive stream so reading it honours cancellation
+// and is counted as progress.
+func (x *Extractor) Reader(r io.Reader) io.Reader {
+	return Reader(x.ctx, x.meter.Reader(r))
+}
+
+// Extract extracts every entry of src. When the context is cancelled,
+// whatever this extraction created is removed again. Directory modes and
+// times are set last, when nothing more is written below them. With
+// WithCheckManifest, the files written are then checked against the manifest.
+// With WithSync, what the archive does not have is removed after a clean run.
+// With WithAtomic, nothing appears at the destination until all is done.
+func (x *Extractor) Extract(src Source) error {
+	err := x.extract(src)
+	if err != nil && x.ctx.Err() != nil {
+		x.rollback()
+		return err
+	}
+	if err == nil && x.kept != nil {
+		err = x.prune()
+	}
+	x.finish()
+	if err == nil && x.sums != nil {
+		err = x.sums.check(x.wanted)
+		if err != nil {
+			log.Errorf("Error checking manifest: %v", err)
+		}
+	}
+	if err == nil && x.final != "" {
+		err = x.commit()
+	}
+	return err
+}
+
+func (x *Extractor) extract(src Source) error {
+	for {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+		header, r, err := src.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			log.Errorf("Error reading archive: %v", err)
+			return err
+		}
+		if err := x.entry(header, r); err != nil {
+			return err
+		}
+	}
+}
+
+func (x *Extractor) entry(header *tar.Header, r io.Reader) error {
+	if header.Typeflag == tar.TypeXGlobalHeader || header.Typeflag == tar.TypeXHeader {
+		log.Debugf("Skipping PAX header: %s", header.Name)
+		return nil
+	}
+	done := func() {}
+	if x.sums != nil {
+		var err error
+		if r, done, err = x.sums.read(header, r); err != nil {
+			return err
+		}
+	}
+
+	if !x.selected(header) {
+		log.Debugf("Skipping unselected entry: %s", header.Name)
+		x.excluded[path.Clean(header.Name)] = true
+		return nil
+	}
+	if header.Typeflag == tar.TypeLink && x.excluded[path.Clean(header.Linkname)] {
+		log.Errorf("Error extracting hard link: %s -> %s was left out", header.Name, header.Linkname)
+		return fmt.Errorf("%w: %s -> %s", ErrLinkTargetExcluded, header.Name, header.Linkname)
+	}
+	mapped, ok := x.cfg.mapHeader(header)
+	if !ok {
+		log.Debugf("Skipping entry dropped by renaming: %s", header.Name)
+		x.excluded[path.Clean(header.Name)] = true
+		return nil
+	}
+	header = mapped
+
+	name, err := LocalName(header.Name)
+	if err != nil {
+		log.Errorf("Security violation: trying to write outside destination directory: %s", header.Name)
+		return err
+	}
+	if x.kept != nil {
+		x.keep(name)
+	}
+	x.meter.Entry(header.Name, header.Size)
+
+	switch header.Typeflag {
+	case tar.TypeReg:
+		if err := x.writeFile(name, header, r); err != nil {
+			return err
+		}
+		if x.written[name] || x.skipped[name] {
+			done()
+		}
+	case tar.TypeDir:
+		return x.mkdir(name, header)
+	case tar.TypeSymlink:
+		return x.symlink(name, header)
+	case tar.TypeLink:
+		if err := x.link(name, header); err != nil {
+			return err
+		}
+		if x.written[name] || x.skipped[name] {
+			done()
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		// Special files - usually skipped in most implementations
+		log.Debugf("Skipping special file: %s (type: %c)", header.Name, header.Typeflag)
+	default:
+		log.Errorf("Unsupported tar entry type: %c in %s", header.Typeflag, header.Name)
+	}
+	return nil
+}
+
+// wanted reports whether the file called name in the archive is one
+// Extract writes, so one the manifest check expects to find.
+func (x *Extractor) wanted(name string) bool {
+	_, ok := x.cfg.MapName(name)
+	return ok && x.cfg.Selected(name)
+}
+
+// selected reports whether header passes the include and exclude patterns.
+// A link that does not match itself is still restored when its target does.
+func (x *Extractor) selected(header *tar.Header) bool {
+	name := path.Clean(header.Name)
+	if x.cfg.Selected(name) {
+		return true
+	}
+	if matchAny(x.cfg.Exclude, name) {
+		return false
+	}
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		if path.IsAbs(header.Linkname) {
+			return false
+		}
+		return x.cfg.Selected(path.Join(path.Dir(name), header.Linkname))
+	case tar.TypeLink:
+		return x.cfg.Selected(path.Clean(header.Linkname))
+	}
+	return false
+}
+
+func (x *Extractor) writeFile(name string, header *tar.Header, r io.Reader) error {
+	if err := x.mkdirAll(filepath.Dir(name), 0755); err != nil {
+		log.Errorf("Error creating parent directory: %v", err)
+		return err
+	}
+	if skip, err := x.skipUnchanged(name, header, r); skip || err != nil {
+		return err
+	}
+	// Whatever is there is removed first, so nothing is written through a
+	// symlink or into another hard link of it
+	if ok, err := x.claim(name, header.ModTime); !ok {
+		if err == nil && x.sums != nil {
+			// Still checked against the manifest, as with WithSync
+			_, err = Copy(x.ctx, io.Discard, r)
+		}
+		return err
+	}
+
+	file, err := x.root.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		log.Errorf("Error opening file: %v, %s", err, name)
+		return err
+	}
+	_, err = Copy(x.ctx, x.meter.Writer(file), r)
+	if err != nil {
+		_ = file.Close()
+		log.Errorf("Error copying file: %v", err)
+		return err
+	}
+	if x.cfg.Xattrs {
+		x.setXattrs(file, header)
+	}
+	if err := file.Close(); err != nil {
+		log.Errorf("Error closing file: %v", err)
+		return err
+	}
+
+	if x.owner {
+		// After chown, as that clears setuid and setgid
+		x.chown(name, header)
+		if err := x.root.Chmod(name, header.FileInfo().Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			log.Warnf("Could not set file mode: %v", err)
+		}
+	}
+	if err := x.root.Chtimes(name, header.AccessTime, header.ModTime); err != nil {
+		log.Warnf("Could not set file times: %v", err)
+	}
+	return nil
+}
+
+func (x *Extractor) mkdir(name string, header *tar.Header) error {
+	if _, err := x.root.Lstat(name); os.IsNotExist(err) {
+		x.changes.Created = append(x.changes.Created, filepath.ToSlash(name))
+	}
+	// Writable until finish, even if the archive says it is read-only
+	if err := x.mkdirAll(name, header.FileInfo().Mode().Perm()|0o700); err != nil {
+		log.Errorf("Error creating directory: %v", err)
+		return err
+	}
+	x.dirs = append(x.dirs, dirMeta{name: name, header: *header})
+	return nil
+}
+
+func (x *Extractor) symlink(name string, header *tar.Header) error {
+	if err := checkSymlink(name, header.Linkname); err != nil {
+		log.Errorf("Security violation: symlink points outside destination: %s -> %s", header.Name, header.Linkname)
+		return err
+	}
+	// Not joined with filepath.Join, which would take ".." lexically
+	// rather than from where the symlinks already written lead
+	if _, err := x.resolve(filepath.Dir(name) + string(filepath.Separator) + filepath.FromSlash(header.Linkname)); err != nil {
+		log.Errorf("Security violation: symlink points outside destination: %s -> %s", header.Name, header.Linkname)
+		return fmt.Errorf("%w: %s -> %s", err, header.Name, header.Linkname)
+	}
+	if err := x.mkdirAll(filepath.Dir(name), 0755); err != nil {
+		log.Errorf("Error creating parent directory: %v", err)
+		return err
+	}
+	if x.cfg.Sync && x.unchanged(name, header) {
+		return nil
+	}
+	if ok, err := x.claim(name, header.ModTime); !ok {
+		return err
+	}
+	if err := x.root.Symlink(header.Linkname, name); err != nil {
+		log.Errorf("Error creating symlink: %v", err)
+		return err
+	}
+	x.chown(name, header)
+	return nil
+}
+
+func (x *Extractor) link(name string, header *tar.Header) error {
+	target, err := LocalName(header.Linkname)
+	if err != nil {
+		log.Errorf("Security violation: hard link points outside destination: %s", header.Linkname)
+		return err
+	}
+	if err := x.mkdirAll(filepath.Dir(name), 0755); err != nil {
+		log.Errorf("Error creating directory: %v", err)
+		return err
+	}
+	// Hard link source must have been extracted already
+	if _, err := x.root.Lstat(target); os.IsNotExist(err) {
+		log.Errorf("Hard link target does not exist: %s", header.Linkname)
+		return nil
+	}
+	if x.cfg.Sync && x.unchanged(name, header) {
+		return nil
+	}
+	if ok, err := x.claim(name, header.ModTime); !ok {
+		return err
+	}
+	if err := x.root.Link(target, name); err != nil {
+		if !x.cfg.CopyLinks {
+			log.Errorf("Error creating hard link: %v", err)
+			return nil
+		}
+		log.Warnf("Could not create hard link, copying instead: %v", err)
+		return x.copyFile(target, name)
+	}
+	return nil
+}
+
+// copyFile copies the regular file src to dst, with its mode and times.
+func (x *Extractor) copyFile(src, dst string) error {
+	in, err := x.root.Open(src)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(in)
+	info, err := in.Stat()
+	if err != nil {
+		log.Errorf("Error stating file: %v", err)
+		return err
+	}
+	out, err := x.root.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		log.Errorf("Error opening file: %v, %s", err, dst)
+		return err
+	}
+	if _, err := Copy(x.ctx, out, in); err != nil {
+		_ = out.Close()
+		log.Errorf("Error copying file: %v", err)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		log.Errorf("Error closing file: %v", err)
+		return err
+	}
+	if err := x.root.Chtimes(dst, time.Time{}, info.ModTime()); err != nil {
+		log.Warnf("Could not set file times: %v", err)
+	}
+	return nil
+}
+
+// track records name as created by this extraction if nothing is there yet.
+func (x *Extractor) track(name string) {
+	if _, err := x.root.Lstat(name); os.IsNotExist(err) {
+		x.created = append(x.created, name)
+	}
+}
+
+// mkdirAll is Root.MkdirAll that records the topmost directory it creates.
+func (x *Extractor) mkdirAll(name string, perm os.FileMode) error {
+	missing := ""
+	for p := name; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		if _, err := x.root.Lstat(p); !os.IsNotExist(err) {
+			break
+		}
+		missing = p
+	}
+	if err := x.root.MkdirAll(name, perm); err != nil {
+		return err
+	}
+	if missing != "" {
+		x.created = append(x.created, missing)
+	}
+	return nil
+}
+
+// rollback removes everything this extraction created, newest first.
+func (x *Extractor) rollback() {
+	for i := len(x.created) - 1; i >= 0; i-- {
+		_ = x.root.RemoveAll(x.created[i])
+	}
+	x.created = nil
+	if x.destCreated {
+		_ = os.RemoveAll(x.dest)
+	}
+}
+
+// LocalName turns an archive entry name into a path relative to the
+// destination, rejecting absolute names and names that climb out with "..".
+func LocalName(name string) (string, error) {
+	local := filepath.FromSlash(path.Clean(name))
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("%w: %s", ErrInsecurePath, name)
+	}
+	return local, nil
+}
+
+// maxLinks is how many symlinks resolve follows before giving up.
+const maxLinks = 255
+
+// resolve follows the symlinks already in dest along name, one element at
+// a time, and returns where it leads, or ErrInsecurePath if that is outside
+// dest. Elements that don't exist yet are taken as they are.
+func (x *Extractor) resolve(name string) (string, error) {
+	resolved := "."
+	parts := strings.Split(name, string(filepath.Separator))
+	for links := 0; len(parts) > 0; {
+		next := filepath.Join(resolved, parts[0])
+		parts = parts[1:]
+		if !filepath.IsLocal(next) {
+			return "", ErrInsecurePath
+		}
+		info, err := x.root.Lstat(next)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxLinks {
+			return "", ErrInsecurePath
+		}
+		target, err := x.root.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+			return "", ErrInsecurePath
+		}
+		// The target is relative to the directory the symlink is in
+		parts = append(strings.Split(target, string(filepath.Separator)), parts...)
+	}
+	return resolved, nil
+}
+
+// checkSymlink rejects symlink targets that are absolute
+// or resolve outside the destination from where the link lives.
+func checkSymlink(name, target string) error {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return fmt.Errorf("%w: %s -> %s", ErrInsecurePath, name, target)
+	}
+	resolved := filepath.Join(filepath.Dir(name), filepath.FromSlash(target))
+	if !filepath.IsLocal(resolved) {
+		return fmt.Errorf("%w: %s -> %s", ErrInsecurePath, name, target)
+	}
+	return nil
+}
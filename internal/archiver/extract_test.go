@@ -0,0 +1,378 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	header tar.Header
+	body   string
+}
+
+func tarOf(t *testing.T, entries ...testEntry) *tar.Reader {
+	return tar.NewReader(bytes.NewReader(tarBytes(t, entries...)))
+}
+
+func tarBytes(t *testing.T, entries ...testEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		e.header.Size = int64(len(e.body))
+		if e.header.Mode == 0 {
+			e.header.Mode = 0644
+		}
+		if err := tw.WriteHeader(&e.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func extract(t *testing.T, ctx context.Context, dest string, tr *tar.Reader) error {
+	x, err := NewExtractor(ctx, dest, NewConfig(), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	return x.Extract(TarSource(tr))
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	cases := map[string]testEntry{
+		"dotdot":   {header: tar.Header{Name: "../evil.txt", Typeflag: tar.TypeReg}, body: "x"},
+		"absolute": {header: tar.Header{Name: "/tmp/evil.txt", Typeflag: tar.TypeReg}, body: "x"},
+		"symlink":  {header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+		"abs link": {header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		"hardlink": {header: tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "../outside"}},
+	}
+	for name, entry := range cases {
+		dest := filepath.Join(t.TempDir(), "dest")
+		err := extract(t, context.Background(), dest, tarOf(t, entry))
+		if !errors.Is(err, ErrInsecurePath) {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, ErrInsecurePath, err)
+		}
+	}
+}
+
+func TestExtractRejectsSymlinkChains(t *testing.T) {
+	cases := map[string][]testEntry{
+		// dir1/.. looks local, but dir1 is dest itself
+		"parent": {
+			{header: tar.Header{Name: "dir1", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{header: tar.Header{Name: "dir1/dir2", Typeflag: tar.TypeSymlink, Linkname: ".."}},
+		},
+		"target": {
+			{header: tar.Header{Name: "dir1", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{header: tar.Header{Name: "dir2", Typeflag: tar.TypeSymlink, Linkname: "dir1/.."}},
+		},
+	}
+	for name, entries := range cases {
+		dest := filepath.Join(t.TempDir(), "dest")
+		err := extract(t, context.Background(), dest, tarOf(t, entries...))
+		if !errors.Is(err, ErrInsecurePath) {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, ErrInsecurePath, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dest, "dir2")); !os.IsNotExist(err) {
+			t.Errorf("Test failed for %s, expected no dir2, got:  '%v'", name, err)
+		}
+	}
+}
+
+func TestExtractThroughSymlinkStaysInside(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "dest")
+	err := extract(t, context.Background(), dest, tarOf(t,
+		testEntry{header: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+		testEntry{header: tar.Header{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "dir"}},
+		testEntry{header: tar.Header{Name: "up/file.txt", Typeflag: tar.TypeReg}, body: "hello"},
+	))
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "dir", "file.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Test failed, expected: 'hello', got:  '%s' (%v)", data, err)
+	}
+}
+
+func TestExtractRollbackOnCancel(t *testing.T) {
+	dest := t.TempDir()
+	existing := filepath.Join(dest, "existing.txt")
+	if err := os.WriteFile(existing, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	x, err := NewExtractor(ctx, dest, NewConfig(WithProgress(func(p Progress) {
+		if p.Name == "b/c.txt" {
+			cancel()
+		}
+	})), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	err = x.Extract(TarSource(tarOf(t,
+		testEntry{header: tar.Header{Name: "a/b/file.txt", Typeflag: tar.TypeReg}, body: "hello"},
+		testEntry{header: tar.Header{Name: "b/c.txt", Typeflag: tar.TypeReg}, body: "hello"},
+	)))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", context.Canceled, err)
+	}
+	for _, p := range []string{"a", "b"} {
+		if _, err := os.Lstat(filepath.Join(dest, p)); !os.IsNotExist(err) {
+			t.Errorf("Test failed, %s should be removed", p)
+		}
+	}
+	if _, err := os.Lstat(existing); err != nil {
+		t.Errorf("Test failed, %s should be kept: %v", existing, err)
+	}
+}
+
+func TestExtractAtomic(t *testing.T) {
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "tool-1.0")
+	good := tarBytes(t,
+		testEntry{header: tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755}, body: "#!/bin/sh"},
+	)
+	bad := tarBytes(t,
+		testEntry{header: tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg}, body: "#!/bin/sh"},
+		testEntry{header: tar.Header{Name: "../evil", Typeflag: tar.TypeReg}, body: "x"},
+	)
+	atomic := func(data []byte, progress func(Progress)) error {
+		x, err := NewExtractor(context.Background(), dest, NewConfig(WithAtomic(), WithProgress(progress)), -1)
+		if err != nil {
+			return err
+		}
+		defer x.Close()
+		return x.Extract(TarSource(tar.NewReader(bytes.NewReader(data))))
+	}
+	left := func() []os.DirEntry {
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	if err := atomic(bad, nil); !errors.Is(err, ErrInsecurePath) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrInsecurePath, err)
+	}
+	if entries := left(); len(entries) != 0 {
+		t.Errorf("Test failed, expected nothing left behind, got:  '%v'", entries)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Test failed, expected the panic to go through")
+			}
+		}()
+		_ = atomic(good, func(Progress) { panic("boom") })
+	}()
+	if entries := left(); len(entries) != 0 {
+		t.Errorf("Test failed, expected nothing left behind after a panic, got:  '%v'", entries)
+	}
+
+	if err := atomic(good, nil); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "bin", "tool"))
+	if err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Test failed, expected an executable bin/tool, got:  '%v' (%v)", info, err)
+	}
+	if entries := left(); len(entries) != 1 {
+		t.Errorf("Test failed, expected only %s, got:  '%v'", dest, entries)
+	}
+
+	if err := atomic(good, nil); !errors.Is(err, ErrDestinationNotEmpty) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrDestinationNotEmpty, err)
+	}
+}
+
+func TestExtractPolicies(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := tarBytes(t,
+		testEntry{header: tar.Header{Name: "old.txt", Typeflag: tar.TypeReg, ModTime: modTime}, body: "archive"},
+		testEntry{header: tar.Header{Name: "edited.txt", Typeflag: tar.TypeReg, ModTime: modTime}, body: "archive"},
+		testEntry{header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "old.txt", ModTime: modTime}},
+		testEntry{header: tar.Header{Name: "new.txt", Typeflag: tar.TypeReg, ModTime: modTime}, body: "archive"},
+		testEntry{header: tar.Header{Name: "new.txt", Typeflag: tar.TypeReg, ModTime: modTime}, body: "archive"},
+	)
+	prepare := func(t *testing.T) string {
+		dest := t.TempDir()
+		for name, at := range map[string]time.Time{"old.txt": modTime.Add(-time.Hour), "edited.txt": modTime.Add(time.Hour)} {
+			if err := os.WriteFile(filepath.Join(dest, name), []byte("user"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(filepath.Join(dest, name), at, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink("edited.txt", filepath.Join(dest, "link")); err != nil {
+			t.Fatal(err)
+		}
+		return dest
+	}
+	cases := []struct {
+		opt     Option
+		changes Changes
+		user    []string
+		err     error
+	}{
+		{WithPolicy(Overwrite), Changes{Created: []string{"new.txt"}, Replaced: []string{"old.txt", "edited.txt", "link"}}, nil, nil},
+		{WithPolicy(SkipExisting), Changes{Created: []string{"new.txt"}, Skipped: []string{"old.txt", "edited.txt", "link"}}, []string{"old.txt", "edited.txt"}, nil},
+		{WithPolicy(KeepNewer), Changes{Created: []string{"new.txt"}, Replaced: []string{"old.txt"}, Skipped: []string{"edited.txt", "link"}}, []string{"edited.txt"}, nil},
+		{WithPolicy(FailOnConflict), Changes{}, []string{"old.txt", "edited.txt"}, ErrConflict},
+		{WithBackup(".orig"), Changes{Created: []string{"new.txt"}, Replaced: []string{"old.txt", "edited.txt", "link"}}, []string{"old.txt.orig", "edited.txt.orig"}, nil},
+	}
+	for i, c := range cases {
+		dest := prepare(t)
+		changes := Changes{}
+		x, err := NewExtractor(context.Background(), dest, NewConfig(c.opt, WithChanges(&changes)), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = x.Extract(TarSource(tar.NewReader(bytes.NewReader(data))))
+		_ = x.Close()
+		if !errors.Is(err, c.err) {
+			t.Errorf("Test %d failed, expected: '%v', got:  '%v'", i, c.err, err)
+		}
+		if !reflect.DeepEqual(changes, c.changes) {
+			t.Errorf("Test %d failed, expected: '%+v', got:  '%+v'", i, c.changes, changes)
+		}
+		for _, name := range c.user {
+			if content, err := os.ReadFile(filepath.Join(dest, name)); string(content) != "user" {
+				t.Errorf("Test %d failed, expected: '%v', got:  '%s' (%v)", i, "user", content, err)
+			}
+		}
+	}
+}
+
+func TestExtractSync(t *testing.T) {
+	dest := t.TempDir()
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v1 := tarBytes(t,
+		testEntry{header: tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755, ModTime: modTime}, body: "v1"},
+		testEntry{header: tar.Header{Name: "lib/a.jar", Typeflag: tar.TypeReg, ModTime: modTime}, body: "same"},
+		testEntry{header: tar.Header{Name: "lib/old.jar", Typeflag: tar.TypeReg, ModTime: modTime}, body: "old"},
+		testEntry{header: tar.Header{Name: "doc/README", Typeflag: tar.TypeReg, ModTime: modTime}, body: "docs"},
+		testEntry{header: tar.Header{Name: "tool", Typeflag: tar.TypeSymlink, Linkname: "bin/tool"}},
+	)
+	v2 := tarBytes(t,
+		testEntry{header: tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755, ModTime: modTime.Add(time.Hour)}, body: "v2"},
+		testEntry{header: tar.Header{Name: "lib/a.jar", Typeflag: tar.TypeReg, ModTime: modTime}, body: "same"},
+		testEntry{header: tar.Header{Name: "lib/b.jar", Typeflag: tar.TypeReg, Mode: 0600, ModTime: modTime}, body: "new"},
+		testEntry{header: tar.Header{Name: "tool", Typeflag: tar.TypeSymlink, Linkname: "bin/tool"}},
+	)
+	sync := func(data []byte) Changes {
+		changes := Changes{}
+		x, err := NewExtractor(context.Background(), dest, NewConfig(WithSync(), WithChanges(&changes)), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer x.Close()
+		if err := x.Extract(TarSource(tar.NewReader(bytes.NewReader(data)))); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		return changes
+	}
+
+	sync(v1)
+	before, err := os.Stat(filepath.Join(dest, "lib", "a.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "local.conf"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := sync(v2)
+	expected := Changes{
+		Created:  []string{"lib/b.jar"},
+		Replaced: []string{"bin/tool"},
+		Removed:  []string{"doc", "lib/old.jar", "local.conf"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Test failed, expected: '%+v', got:  '%+v'", expected, changes)
+	}
+	if after, err := os.Stat(filepath.Join(dest, "lib", "a.jar")); err != nil || !os.SameFile(before, after) {
+		t.Errorf("Test failed, expected lib/a.jar to be left alone, got:  '%v' (%v)", after, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dest, "tool")); string(content) != "v2" {
+		t.Errorf("Test failed, expected: '%v', got:  '%s' (%v)", "v2", content, err)
+	}
+
+	// Only the mode differs
+	if err := os.Chmod(filepath.Join(dest, "lib", "b.jar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes = sync(v2)
+	if expected := (Changes{Updated: []string{"lib/b.jar"}}); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Test failed, expected: '%+v', got:  '%+v'", expected, changes)
+	}
+}
+
+func TestExtractPoliciesCheckManifest(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "pkg")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cfg := NewConfig(WithManifest())
+	tw := tar.NewWriter(&buf)
+	if err := WriteTar(context.Background(), tw, []string{src}, cfg, cfg.Meter(-1)); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range []Policy{SkipExisting, KeepNewer} {
+		dest := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dest, "pkg"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Hour)
+		for _, name := range []string{"a.txt", "b.txt"} {
+			if err := os.WriteFile(filepath.Join(dest, "pkg", name), []byte("user"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(filepath.Join(dest, "pkg", name), later, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+		changes := Changes{}
+		x, err := NewExtractor(context.Background(), dest, NewConfig(WithPolicy(policy), WithCheckManifest(), WithChanges(&changes)), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = x.Extract(TarSource(tar.NewReader(bytes.NewReader(buf.Bytes()))))
+		_ = x.Close()
+		if err != nil {
+			t.Errorf("Test failed for policy %d, expected: '%v', got:  '%v'", policy, nil, err)
+		}
+		if expected := []string{"pkg/a.txt", "pkg/b.txt"}; !reflect.DeepEqual(changes.Skipped, expected) {
+			t.Errorf("Test failed for policy %d, expected: '%v', got:  '%v'", policy, expected, changes.Skipped)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// maxLinkHops bounds symlink resolution, as a loop would otherwise never end.
+const maxLinkHops = 255
+
+// FS is a read-only file system over the members of an archive. It is
+// indexed once when opened, so lookups never re-read the archive from the
+// start. Symlinks are followed as long as they stay inside the archive.
+// It is safe for concurrent use.
+type FS struct {
+	nodes  map[string]*node
+	closer func() error
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadLinkFS = (*FS)(nil)
+)
+
+type node struct {
+	header   *tar.Header
+	children []string // base names, sorted once indexing is done
+
+	// content of a regular file: either a range of ra, or reopened from open
+	ra     io.ReaderAt
+	offset int64
+	open   func() (io.ReadCloser, error)
+}
+
+func newFS() *FS {
+	return &FS{nodes: map[string]*node{
+		".": {header: &tar.Header{Name: ".", Typeflag: tar.TypeDir, Mode: 0755}},
+	}}
+}
+
+// NewTarFS indexes the tar stream r. Since a compressed stream cannot be
+// seeked, the content of regular files is spooled to a temporary file,
+// which Close removes.
+func NewTarFS(ctx context.Context, r io.Reader) (*FS, error) {
+	spool, err := os.CreateTemp("", "archiver-*.tar")
+	if err != nil {
+		log.Errorf("Error creating spool file: %v", err)
+		return nil, err
+	}
+	fsys := newFS()
+	fsys.closer = func() error {
+		err := spool.Close()
+		if err := os.Remove(spool.Name()); err != nil {
+			log.Errorf("Error removing spool file: %v", err)
+		}
+		return err
+	}
+
+	tr := tar.NewReader(Reader(ctx, r))
+	var offset int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Error reading tar: %v", err)
+			_ = fsys.Close()
+			return nil, err
+		}
+		n := &node{header: header}
+		if header.Typeflag == tar.TypeReg {
+			size, err := io.Copy(spool, tr)
+			if err != nil {
+				log.Errorf("Error spooling %s: %v", header.Name, err)
+				_ = fsys.Close()
+				return nil, err
+			}
+			n.ra, n.offset = spool, offset
+			offset += size
+		}
+		fsys.add(n)
+	}
+	fsys.finish()
+	return fsys, nil
+}
+
+// countingReader tracks its offset, and can seek so tar.Reader skips file
+// content instead of reading it.
+type countingReader struct {
+	r io.ReadSeeker
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := c.r.Seek(offset, whence)
+	if err == nil {
+		c.n = n
+	}
+	return n, err
+}
+
+// NewTarFSAt indexes an uncompressed tar that can be read at random,
+// such as a plain .tar file, without copying anything; closer, if not nil,
+// is called by Close. Only the headers are read.
+func NewTarFSAt(ctx context.Context, ra io.ReaderAt, size int64, closer io.Closer) (*FS, error) {
+	counter := &countingReader{r: io.NewSectionReader(ra, 0, size)}
+	tr := tar.NewReader(counter)
+	fsys := newFS()
+	if closer != nil {
+		fsys.closer = closer.Close
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			_ = fsys.Close()
+			return nil, err
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Error reading tar: %v", err)
+			_ = fsys.Close()
+			return nil, err
+		}
+		n := &node{header: header}
+		if header.Typeflag == tar.TypeReg {
+			// tar.Reader stops right after the header blocks
+			n.ra, n.offset = ra, counter.n
+		}
+		fsys.add(n)
+	}
+	fsys.finish()
+	return fsys, nil
+}
+
+// NewZipFS indexes the members of a zip archive; closer, if not nil,
+// is called by Close.
+func NewZipFS(files []*zip.File, closer io.Closer) (*FS, error) {
+	fsys := newFS()
+	if closer != nil {
+		fsys.closer = closer.Close
+	}
+	for _, f := range files {
+		header := ZipHeader(f)
+		n := &node{header: header}
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			rc, err := f.Open()
+			if err != nil {
+				log.Errorf("Error opening Symlink: %v", err)
+				_ = fsys.Close()
+				return nil, err
+			}
+			buf := new(bytes.Buffer)
+			_, err = io.Copy(buf, rc)
+			_ = rc.Close()
+			if err != nil {
+				log.Errorf("Error copying Symlink: %v", err)
+				_ = fsys.Close()
+				return nil, err
+			}
+			header.Linkname = buf.String()
+		case tar.TypeReg:
+			n.open = f.Open
+		}
+		fsys.add(n)
+	}
+	fsys.finish()
+	return fsys, nil
+}
+
+// fsName turns an archive member name into an fs.FS name,
+// or "" if it is not one, e.g. because it climbs out with "..".
+func fsName(name string) string {
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "."
+	}
+	if !fs.ValidPath(name) {
+		return ""
+	}
+	return name
+}
+
+func (fsys *FS) add(n *node) {
+	switch n.header.Typeflag {
+	case tar.TypeXGlobalHeader, tar.TypeXHeader:
+		return
+	}
+	if strings.HasPrefix(path.Clean(n.header.Name), "../") || path.Clean(n.header.Name) == ".." {
+		log.Warnf("Skipping %s outside of the archive root", n.header.Name)
+		return
+	}
+	name := fsName(n.header.Name)
+	if name == "" {
+		log.Warnf("Skipping %s with invalid name", n.header.Name)
+		return
+	}
+
+	if n.header.Typeflag == tar.TypeLink {
+		// A hard link shares the content of a member listed before it
+		target, ok := fsys.nodes[fsName(n.header.Linkname)]
+		if !ok || target.header.Typeflag != tar.TypeReg {
+			log.Warnf("Skipping hard link %s to missing %s", n.header.Name, n.header.Linkname)
+			return
+		}
+		header := *target.header
+		header.Name = n.header.Name
+		n = &node{header: &header, ra: target.ra, offset: target.offset, open: target.open}
+	}
+
+	if old, ok := fsys.nodes[name]; ok {
+		// A later member replaces an earlier one, but a directory keeps its children
+		if old.header.Typeflag == tar.TypeDir && n.header.Typeflag == tar.TypeDir {
+			n.children = old.children
+		}
+		fsys.nodes[name] = n
+		return
+	}
+	fsys.nodes[name] = n
+
+	// Make sure every parent exists, even if the archive does not list it
+	for child := name; child != "."; {
+		dir := path.Dir(child)
+		parent, ok := fsys.nodes[dir]
+		if !ok {
+			parent = &node{header: &tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}}
+			fsys.nodes[dir] = parent
+		}
+		parent.children = append(parent.children, path.Base(child))
+		if ok {
+			break
+		}
+		child = dir
+	}
+}
+
+func (fsys *FS) finish() {
+	for _, n := range fsys.nodes {
+		slices.Sort(n.children)
+		n.children = slices.Compact(n.children)
+	}
+}
+
+// Close releases the archive and any spooled content.
+func (fsys *FS) Close() error {
+	if fsys.closer == nil {
+		return nil
+	}
+	return fsys.closer()
+}
+
+// lookup finds name, following symlinks in its directories,
+// and in its last element too if follow is set.
+func (fsys *FS) lookup(op, name string, follow bool) (string, *node, error) {
+	if !fs.ValidPath(name) {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	hops := 0
+	current := "."
+	rest := name
+	for rest != "." && rest != "" {
+		elem, tail, _ := strings.Cut(rest, "/")
+		rest = tail
+		next := path.Join(current, elem)
+		n, ok := fsys.nodes[next]
+		if !ok {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if n.header.Typeflag == tar.TypeSymlink && (rest != "" || follow) {
+			hops++
+			if hops > maxLinkHops || path.IsAbs(n.header.Linkname) {
+				return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			target := path.Join(current, n.header.Linkname)
+			if !fs.ValidPath(target) {
+				return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			rest = path.Join(target, rest)
+			current = "."
+			continue
+		}
+		current = next
+	}
+	return current, fsys.nodes[current], nil
+}
+
+// Open implements fs.FS.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	resolved, n, err := fsys.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := nodeInfo{n: n, name: path.Base(name)}
+	switch n.header.Typeflag {
+	case tar.TypeDir:
+		return &dirFile{fsys: fsys, dir: resolved, info: info}, nil
+	case tar.TypeReg:
+		if n.ra != nil {
+			return &sectionFile{SectionReader: io.NewSectionReader(n.ra, n.offset, n.header.Size), info: info}, nil
+		}
+		return &streamFile{n: n, info: info}, nil
+	default:
+		return &streamFile{n: &node{header: n.header, open: emptyContent}, info: info}, nil
+	}
+}
+
+func emptyContent() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
+
+// Stat implements fs.StatFS.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	_, n, err := fsys.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return nodeInfo{n: n, name: path.Base(name)}, nil
+}
+
+// Lstat implements fs.ReadLinkFS.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	_, n, err := fsys.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return nodeInfo{n: n, name: path.Base(name)}, nil
+}
+
+// ReadLink implements fs.ReadLinkFS.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	_, n, err := fsys.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.header.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.header.Linkname, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, n, err := fsys.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.header.Typeflag != tar.TypeDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return fsys.entries(dir, n), nil
+}
+
+func (fsys *FS) entries(dir string, n *node) []fs.DirEntry {
+	result := make([]fs.DirEntry, 0, len(n.children))
+	for _, child := range n.children {
+		c := fsys.nodes[path.Join(dir, child)]
+		result = append(result, fs.FileInfoToDirEntry(nodeInfo{n: c, name: child}))
+	}
+	return result
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f fs.File) {
+		_ = f.Close()
+	}(f)
+	if info, _ := f.Stat(); info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return io.ReadAll(f)
+}
+
+type nodeInfo struct {
+	n    *node
+	name string
+}
+
+func (i nodeInfo) Name() string {
+	if i.name == "." {
+		return "."
+	}
+	return i.name
+}
+func (i nodeInfo) Size() int64        { return i.n.header.Size }
+func (i nodeInfo) Mode() fs.FileMode  { return i.n.header.FileInfo().Mode() }
+func (i nodeInfo) ModTime() time.Time { return i.n.header.ModTime }
+func (i nodeInfo) IsDir() bool        { return i.n.header.Typeflag == tar.TypeDir }
+func (i nodeInfo) Sys() any           { return i.n.header }
+
+type dirFile struct {
+	fsys    *FS
+	dir     string
+	info    nodeInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.dir, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *dirFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries = d.fsys.entries(d.dir, d.info.n)
+		d.read = true
+	}
+	if count <= 0 {
+		result := d.entries
+		d.entries = nil
+		return result, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(d.entries))
+	result := d.entries[:count]
+	d.entries = d.entries[count:]
+	return result, nil
+}
+
+// sectionFile is a regular file read straight from spooled or seekable content.
+type sectionFile struct {
+	*io.SectionReader
+	info nodeInfo
+}
+
+func (f *sectionFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *sectionFile) Close() error               { return nil }
+
+// streamFile is a regular file that can only be read forward, like a
+// compressed zip member. Seeking backwards reopens it, which is enough
+// for http.FileServer sniffing the content type.
+type streamFile struct {
+	n    *node
+	info nodeInfo
+	rc   io.ReadCloser
+	at   int64 // position of rc
+	pos  int64 // position asked for by Seek
+}
+
+func (f *streamFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *streamFile) Read(p []byte) (int, error) {
+	if f.rc == nil || f.pos < f.at {
+		if f.rc != nil {
+			_ = f.rc.Close()
+		}
+		rc, err := f.n.open()
+		if err != nil {
+			return 0, err
+		}
+		f.rc, f.at = rc, 0
+	}
+	if f.pos > f.at {
+		n, err := io.CopyN(io.Discard, f.rc, f.pos-f.at)
+		f.at += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.rc.Read(p)
+	f.at += int64(n)
+	f.pos = f.at
+	return n, err
+}
+
+func (f *streamFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.n.header.Size
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *streamFile) Close() error {
+	if f.rc == nil {
+		return nil
+	}
+	return f.rc.Close()
+}
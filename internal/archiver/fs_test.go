@@ -0,0 +1,92 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func fsEntries() []testEntry {
+	return []testEntry{
+		{header: tar.Header{Name: "jdk/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: tar.Header{Name: "jdk/release", Typeflag: tar.TypeReg}, body: "JAVA_VERSION=21"},
+		{header: tar.Header{Name: "jdk/bin/java", Typeflag: tar.TypeReg, Mode: 0755}, body: "#!java"},
+		{header: tar.Header{Name: "jdk/current", Typeflag: tar.TypeSymlink, Linkname: "bin"}},
+		{header: tar.Header{Name: "jdk/RELEASE", Typeflag: tar.TypeLink, Linkname: "jdk/release"}},
+	}
+}
+
+func checkFS(t *testing.T, fsys *FS) {
+	t.Helper()
+	if err := fstest.TestFS(fsys, "jdk/release", "jdk/bin/java", "jdk/RELEASE"); err != nil {
+		t.Error(err)
+	}
+	data, err := fs.ReadFile(fsys, "jdk/current/java")
+	if err != nil || string(data) != "#!java" {
+		t.Errorf("Test failed, expected: '#!java', got:  '%s' (%v)", data, err)
+	}
+	data, err = fs.ReadFile(fsys, "jdk/RELEASE")
+	if err != nil || string(data) != "JAVA_VERSION=21" {
+		t.Errorf("Test failed, expected: 'JAVA_VERSION=21', got:  '%s' (%v)", data, err)
+	}
+}
+
+func TestTarFS(t *testing.T) {
+	data := tarBytes(t, fsEntries()...)
+
+	fsys, err := NewTarFS(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFS(t, fsys)
+	if err := fsys.Close(); err != nil {
+		t.Error(err)
+	}
+
+	fsys, err = NewTarFSAt(context.Background(), bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFS(t, fsys)
+}
+
+func TestZipFS(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range fsEntries() {
+		if e.header.Typeflag == tar.TypeLink {
+			e = testEntry{header: tar.Header{Name: e.header.Name, Typeflag: tar.TypeReg}, body: "JAVA_VERSION=21"}
+		}
+		fh, err := zip.FileInfoHeader(e.header.FileInfo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fh.Name = e.header.Name
+		if e.header.Typeflag == tar.TypeSymlink {
+			e.body = e.header.Linkname
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewZipFS(zr.File, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFS(t, fsys)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package archiver
+
+import "io/fs"
+
+// hardLinked reports false, as this system gives no inode to tell.
+func hardLinked(fs.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package archiver
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// hardLinked returns the key of a file that has more than one name.
+func hardLinked(info fs.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(stat.Dev), ino: stat.Ino}, true
+}
@@ -0,0 +1,43 @@
+package archiver
+
+import (
+	"archive/tar"
+	"io/fs"
+)
+
+// fileKey identifies a file across its names.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// HardLinks remembers the files of a walk that have more than one name, so
+// every later name can be stored as a hard link to the first.
+type HardLinks struct {
+	seen map[fileKey]string
+}
+
+// NewHardLinks returns an empty HardLinks.
+func NewHardLinks() *HardLinks {
+	return &HardLinks{seen: map[fileKey]string{}}
+}
+
+// Link turns header, for the file with info, into a hard link if the file
+// was stored under another name before, and otherwise remembers name.
+// It reports whether header is now a link, with no content to write.
+func (l *HardLinks) Link(header *tar.Header, info fs.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	key, ok := hardLinked(info)
+	if !ok {
+		return false
+	}
+	if first, ok := l.seen[key]; ok {
+		header.Typeflag = tar.TypeLink
+		header.Linkname = first
+		header.Size = 0
+		return true
+	}
+	l.seen[key] = header.Name
+	return false
+}
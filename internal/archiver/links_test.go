@@ -0,0 +1,34 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyLinkFallback(t *testing.T) {
+	dest := t.TempDir()
+	x, err := NewExtractor(context.Background(), dest, NewConfig(WithCopyLinks()), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	tr := tarOf(t, testEntry{header: tar.Header{Name: "bin/git", Typeflag: tar.TypeReg, Mode: 0o755}, body: "git"})
+	if err := x.Extract(TarSource(tr)); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	if err := x.copyFile(filepath.Join("bin", "git"), "git-core"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "git-core"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dest, "git-core"))
+	if string(data) != "git" || info.Mode().Perm()&0o100 == 0 {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' %v", "git", string(data), info.Mode())
+	}
+}
@@ -0,0 +1,337 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/util"
+)
+
+// ManifestName is the archive entry holding the manifest, written last.
+const ManifestName = ".manifest.sha256.json"
+
+var (
+	// ErrNoManifest is returned when a manifest is to be checked but the
+	// archive has none.
+	ErrNoManifest = errors.New("archive has no manifest")
+	// ErrManifestMismatch marks files that differ from the manifest.
+	ErrManifestMismatch = errors.New("does not match the manifest")
+)
+
+// ManifestFile is what a manifest records of one regular file.
+type ManifestFile struct {
+	Name   string      `json:"name"`
+	Size   int64       `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+	SHA256 string      `json:"sha256"`
+}
+
+// Manifest lists the regular files of an archive with their checksums.
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+}
+
+// ParseManifest reads a manifest as written by Compress.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("bad manifest: %w", err)
+	}
+	return m, nil
+}
+
+// LoadManifest parses the manifest entry as returned by ReadFile, taking an
+// entry that does not exist for ErrNoManifest.
+func LoadManifest(data []byte, err error) (*Manifest, error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoManifest
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+// IsManifest reports whether an entry called name is the manifest.
+func IsManifest(name string) bool {
+	return path.Clean(name) == ManifestName
+}
+
+// check adds to report every file of m that is not in sums with the same
+// checksum. Files missing from sums are only reported if want says so.
+func (m *Manifest) check(sums map[string]string, want func(name string) bool, report *Report) {
+	for _, f := range m.Files {
+		sum, ok := sums[f.Name]
+		switch {
+		case !ok && want(f.Name):
+			report.Add(f.Name, fmt.Errorf("missing, %w", ErrManifestMismatch))
+		case ok && sum != f.SHA256:
+			report.Add(f.Name, fmt.Errorf("checksum %w", ErrManifestMismatch))
+		}
+	}
+}
+
+// Audit checks the files below dir, where the archive was extracted with
+// opts, against m, reporting those that are missing or differ in size,
+// permissions or content. Files not in m are not looked at.
+func (m *Manifest) Audit(dir string, opts ...Option) (*Report, error) {
+	cfg := NewConfig(opts...)
+	dir, err := util.ExpandHome(dir)
+	if err != nil {
+		log.Errorf("Error expanding home dir: %v", err)
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		log.Errorf("Error opening directory: %v", err)
+		return nil, err
+	}
+	defer func(root *os.Root) {
+		err := root.Close()
+		if err != nil {
+			log.Errorf("Error closing directory: %v", err)
+		}
+	}(root)
+
+	report := &Report{}
+	for _, f := range m.Files {
+		mapped, ok := cfg.MapName(f.Name)
+		if !ok || !cfg.Selected(f.Name) {
+			continue
+		}
+		report.Entries++
+		name, err := LocalName(mapped)
+		if err != nil {
+			report.Add(f.Name, err)
+			continue
+		}
+		if err := auditFile(root, name, f, report); err != nil {
+			report.Add(f.Name, err)
+		}
+	}
+	return report, nil
+}
+
+func auditFile(root *os.Root, name string, f ManifestFile, report *Report) error {
+	file, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	switch {
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("not a regular file, %w", ErrManifestMismatch)
+	case info.Size() != f.Size:
+		return fmt.Errorf("size %d %w", info.Size(), ErrManifestMismatch)
+	case info.Mode().Perm() != f.Mode.Perm():
+		return fmt.Errorf("mode %v %w", info.Mode().Perm(), ErrManifestMismatch)
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	report.Bytes += n
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != f.SHA256 {
+		return fmt.Errorf("checksum %w", ErrManifestMismatch)
+	}
+	return nil
+}
+
+// ManifestBuilder collects the manifest while Compress adds files.
+// A nil *ManifestBuilder does nothing, so callers need not check
+// whether a manifest was asked for.
+type ManifestBuilder struct {
+	manifest Manifest
+	index    map[string]int
+	hash     hash.Hash
+	modTime  time.Time
+}
+
+// NewManifest returns a ManifestBuilder if WithManifest was given, or nil.
+func (c *Config) NewManifest() *ManifestBuilder {
+	if !c.Manifest {
+		return nil
+	}
+	return &ManifestBuilder{index: map[string]int{}, hash: sha256.New()}
+}
+
+// Writer returns w, also hashing what goes through it for the next Add.
+func (b *ManifestBuilder) Writer(w io.Writer) io.Writer {
+	if b == nil {
+		return w
+	}
+	b.hash.Reset()
+	return io.MultiWriter(w, b.hash)
+}
+
+// Add records the file name, whose content just went through Writer.
+func (b *ManifestBuilder) Add(name string, size int64, mode fs.FileMode, modTime time.Time) {
+	if b == nil {
+		return
+	}
+	name = path.Clean(name)
+	b.index[name] = len(b.manifest.Files)
+	b.manifest.Files = append(b.manifest.Files, ManifestFile{
+		Name:   name,
+		Size:   size,
+		Mode:   mode.Perm(),
+		SHA256: hex.EncodeToString(b.hash.Sum(nil)),
+	})
+	if modTime.After(b.modTime) {
+		b.modTime = modTime
+	}
+}
+
+// AddHeader records a regular file or hard link as written to a tar.
+func (b *ManifestBuilder) AddHeader(header *tar.Header) {
+	if b == nil {
+		return
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		b.Add(header.Name, header.Size, fs.FileMode(header.Mode), header.ModTime)
+	case tar.TypeLink:
+		if i, ok := b.index[path.Clean(header.Linkname)]; ok {
+			f := b.manifest.Files[i]
+			f.Name = path.Clean(header.Name)
+			b.index[f.Name] = len(b.manifest.Files)
+			b.manifest.Files = append(b.manifest.Files, f)
+		}
+	}
+}
+
+func (b *ManifestBuilder) content() ([]byte, error) {
+	return json.MarshalIndent(&b.manifest, "", "  ")
+}
+
+// WriteTar adds the manifest to tw as its last entry.
+func (b *ManifestBuilder) WriteTar(tw *tar.Writer, cfg *Config) error {
+	if b == nil {
+		return nil
+	}
+	data, err := b.content()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:     ManifestName,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		ModTime:  b.modTime,
+	}
+	cfg.NormalizeHeader(header)
+	if err := tw.WriteHeader(header); err != nil {
+		log.Errorf("Error writing header: %v", err)
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// WriteZip adds the manifest to zw as its last entry.
+func (b *ManifestBuilder) WriteZip(zw *zip.Writer, cfg *Config) error {
+	if b == nil {
+		return nil
+	}
+	data, err := b.content()
+	if err != nil {
+		return err
+	}
+	header := &zip.FileHeader{Name: ManifestName, Method: cfg.MethodOf(ManifestName), Modified: b.modTime}
+	header.SetMode(0o644)
+	cfg.NormalizeZipHeader(header)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		log.Errorf("Error creating header: %v", err)
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// manifestSums hashes the regular files read by Extract or Verify and
+// picks up the manifest on the way, to check one against the other.
+type manifestSums struct {
+	manifest *Manifest
+	sums     map[string]string
+}
+
+func newManifestSums() *manifestSums {
+	return &manifestSums{sums: map[string]string{}}
+}
+
+// read returns r for entry header, hashing what is read from it under the
+// archive name, and a func that records the sum once r is drained. The
+// manifest itself is read up front and parsed.
+func (s *manifestSums) read(header *tar.Header, r io.Reader) (io.Reader, func(), error) {
+	name := path.Clean(header.Name)
+	if IsManifest(name) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, err := ParseManifest(data)
+		if err != nil {
+			log.Errorf("Error reading manifest: %v", err)
+			return nil, nil, err
+		}
+		s.manifest = m
+		return bytes.NewReader(data), func() {}, nil
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		h := sha256.New()
+		return io.TeeReader(r, h), func() {
+			s.sums[name] = hex.EncodeToString(h.Sum(nil))
+		}, nil
+	case tar.TypeLink:
+		target := path.Clean(header.Linkname)
+		return r, func() {
+			if sum, ok := s.sums[target]; ok {
+				s.sums[name] = sum
+			}
+		}, nil
+	}
+	return r, func() {}, nil
+}
+
+// check compares what was read with the manifest.
+func (s *manifestSums) check(want func(name string) bool) error {
+	if s.manifest == nil {
+		return ErrNoManifest
+	}
+	report := &Report{}
+	s.manifest.check(s.sums, want, report)
+	return report.Err()
+}
+
+// report adds the files that differ from the manifest to report, if there
+// is a manifest.
+func (s *manifestSums) report(report *Report) {
+	if s.manifest != nil {
+		s.manifest.check(s.sums, func(string) bool { return true }, report)
+	}
+}
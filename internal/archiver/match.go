@@ -0,0 +1,79 @@
+package archiver
+
+import (
+	"path"
+	"strings"
+)
+
+// Match reports whether the archive path name matches pattern. Patterns are
+// slash-separated path.Match patterns in which a "**" element matches any
+// number of path elements. A pattern that matches a directory also matches
+// everything below it, so "jdk/bin" selects "jdk/bin/java".
+func Match(pattern, name string) bool {
+	pat := splitPath(pattern)
+	elems := splitPath(name)
+	for i := 1; i <= len(elems); i++ {
+		if matchElems(pat, elems[:i]) {
+			return true
+		}
+	}
+	return len(elems) == 0 && len(pat) == 0
+}
+
+// ValidPattern reports whether pattern is well-formed.
+func ValidPattern(pattern string) bool {
+	for _, p := range splitPath(pattern) {
+		if _, err := path.Match(p, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+func matchElems(pat, elems []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pat, elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], elems[0]); !ok {
+			return false
+		}
+		pat, elems = pat[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Selected reports whether the entry name passes the include and exclude
+// patterns. Exclude wins; no include patterns means everything is included.
+func (c *Config) Selected(name string) bool {
+	if matchAny(c.Exclude, name) {
+		return false
+	}
+	return len(c.Include) == 0 || matchAny(c.Include, name)
+}
@@ -0,0 +1,91 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"jdk/bin", "jdk/bin/java", true},
+		{"jdk/bin/", "jdk/bin", true},
+		{"jdk/bin", "jdk/binaries/x", false},
+		{"*/bin/*", "jdk-21.0.2/bin/java", true},
+		{"**/*.so", "jdk/lib/server/libjvm.so", true},
+		{"**/*.so", "libjvm.so", true},
+		{"jdk/**/server", "jdk/lib/server/libjvm.so", true},
+		{"jdk/**/server", "jdk/server", true},
+		{"jdk/**", "jdk", true},
+		{"lib/*.jar", "lib/ext/a.jar", false},
+		{"./lib", "lib/a.jar", true},
+	}
+	for _, c := range cases {
+		actual := Match(c.pattern, c.name)
+		if actual != c.expected {
+			t.Errorf("Test failed for %q against %q, expected: '%v', got:  '%v'", c.pattern, c.name, c.expected, actual)
+		}
+	}
+	if ValidPattern("[a-") {
+		t.Errorf("Test failed, expected '[a-' to be invalid")
+	}
+}
+
+func TestExtractSelected(t *testing.T) {
+	dest := t.TempDir()
+	tr := tarOf(t,
+		testEntry{header: tar.Header{Name: "sdk/bin/tool", Typeflag: tar.TypeReg}, body: "tool"},
+		testEntry{header: tar.Header{Name: "sdk/lib/a.jar", Typeflag: tar.TypeReg}, body: "jar"},
+		testEntry{header: tar.Header{Name: "sdk/lib/a.txt", Typeflag: tar.TypeReg}, body: "txt"},
+		testEntry{header: tar.Header{Name: "sdk/docs/big.html", Typeflag: tar.TypeReg}, body: "docs"},
+		testEntry{header: tar.Header{Name: "sdk/current", Typeflag: tar.TypeSymlink, Linkname: "bin"}},
+		testEntry{header: tar.Header{Name: "sdk/tool", Typeflag: tar.TypeLink, Linkname: "sdk/bin/tool"}},
+		testEntry{header: tar.Header{Name: "sdk/manual", Typeflag: tar.TypeSymlink, Linkname: "docs"}},
+	)
+	x, err := NewExtractor(context.Background(), dest,
+		NewConfig(WithInclude("sdk/bin", "sdk/lib/**"), WithExclude("**/*.txt")), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	if err := x.Extract(TarSource(tr)); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	for _, name := range []string{"sdk/bin/tool", "sdk/lib/a.jar", "sdk/current", "sdk/tool"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("Test failed, %s should be extracted: %v", name, err)
+		}
+	}
+	for _, name := range []string{"sdk/lib/a.txt", "sdk/docs", "sdk/manual"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); !os.IsNotExist(err) {
+			t.Errorf("Test failed, %s should be skipped", name)
+		}
+	}
+
+	if _, err := NewExtractor(context.Background(), dest, NewConfig(WithInclude("[a-")), -1); err == nil {
+		t.Errorf("Test failed, expected an error for a bad pattern")
+	}
+}
+
+func TestExtractSelectedLinkTargetExcluded(t *testing.T) {
+	dest := t.TempDir()
+	tr := tarOf(t,
+		testEntry{header: tar.Header{Name: "jdk/lib/java", Typeflag: tar.TypeReg}, body: "java"},
+		testEntry{header: tar.Header{Name: "jdk/bin/java", Typeflag: tar.TypeLink, Linkname: "jdk/lib/java"}},
+	)
+	x, err := NewExtractor(context.Background(), dest, NewConfig(WithInclude("jdk/bin")), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	if err := x.Extract(TarSource(tr)); !errors.Is(err, ErrLinkTargetExcluded) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrLinkTargetExcluded, err)
+	}
+}
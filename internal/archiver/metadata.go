@@ -0,0 +1,122 @@
+package archiver
+
+import (
+	"archive/tar"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+)
+
+// xattrPrefix marks the PAX records holding extended attributes, as written
+// by GNU tar and bsdtar.
+const xattrPrefix = "SCHILY.xattr."
+
+// dirMeta is a directory whose mode and times are set once everything below
+// it has been written, since writing children changes them.
+type dirMeta struct {
+	name   string
+	header tar.Header
+}
+
+// finish applies the metadata of the directories, deepest first.
+func (x *Extractor) finish() {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		name, header := x.dirs[i].name, &x.dirs[i].header
+		if err := x.root.Chmod(name, header.FileInfo().Mode().Perm()); err != nil {
+			log.Warnf("Could not set directory mode: %v", err)
+		}
+		x.chown(name, header)
+		if x.cfg.Xattrs && hasXattrs(header) {
+			dir, err := x.root.Open(name)
+			if err != nil {
+				log.Warnf("Could not open directory: %v", err)
+			} else {
+				x.setXattrs(dir, header)
+				_ = dir.Close()
+			}
+		}
+		if err := x.root.Chtimes(name, header.AccessTime, header.ModTime); err != nil {
+			log.Warnf("Could not set directory times: %v", err)
+		}
+	}
+	x.dirs = nil
+}
+
+// chown gives name the owner of header, by user and group name where those
+// exist on this system and by id otherwise, if ownership is preserved.
+// It does not follow symlinks.
+func (x *Extractor) chown(name string, header *tar.Header) {
+	if !x.owner {
+		return
+	}
+	uid := lookupID(x.uids, header.Uname, header.Uid, lookupUser)
+	gid := lookupID(x.gids, header.Gname, header.Gid, lookupGroup)
+	if err := x.root.Lchown(name, uid, gid); err != nil {
+		log.Warnf("Could not set owner of %s: %v", name, err)
+	}
+}
+
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// lookupID resolves a user or group name to its local id with find,
+// remembering the answer in cache, and falls back to id.
+func lookupID(cache map[string]int, name string, id int, find func(string) (string, error)) int {
+	if name == "" {
+		return id
+	}
+	local, ok := cache[name]
+	if !ok {
+		local = -1
+		if s, err := find(name); err == nil {
+			if n, err := strconv.Atoi(s); err == nil {
+				local = n
+			}
+		}
+		cache[name] = local
+	}
+	if local < 0 {
+		return id
+	}
+	return local
+}
+
+func hasXattrs(header *tar.Header) bool {
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setXattrs sets the extended attributes recorded in header on f. Those the
+// file system or our privileges don't allow, such as trusted.* for a normal
+// user, are skipped with a warning.
+func (x *Extractor) setXattrs(f *os.File, header *tar.Header) {
+	for key, value := range header.PAXRecords {
+		name, ok := strings.CutPrefix(key, xattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := setXattr(f, name, []byte(value)); err != nil {
+			log.Warnf("Could not set extended attribute %s on %s: %v", name, header.Name, err)
+		}
+	}
+}
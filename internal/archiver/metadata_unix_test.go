@@ -0,0 +1,82 @@
+//go:build linux || darwin
+
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestExtractDirectoryMetadata(t *testing.T) {
+	dest := t.TempDir()
+	mtime := time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC)
+	tr := tarOf(t,
+		testEntry{header: tar.Header{Name: "ro/", Typeflag: tar.TypeDir, Mode: 0o555, ModTime: mtime}},
+		testEntry{header: tar.Header{Name: "ro/file", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime}, body: "x"},
+		testEntry{header: tar.Header{Name: "ro/sub/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		testEntry{header: tar.Header{Name: "ro/sub/file", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime}, body: "y"},
+	)
+	if err := extract(t, context.Background(), dest, tr); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(dest, "ro"), 0o755) })
+
+	for _, name := range []string{"ro", "ro/sub"} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", name, mtime, info.ModTime())
+		}
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "ro")); info.Mode().Perm() != 0o555 {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", os.FileMode(0o555), info.Mode().Perm())
+	}
+}
+
+func TestExtractOwnerAndXattrs(t *testing.T) {
+	dest := t.TempDir()
+	tr := tarOf(t,
+		testEntry{header: tar.Header{
+			Name: "file", Typeflag: tar.TypeReg, Mode: 0o644, Uid: 4321, Gid: 4321,
+			Uname: "no-such-user-here", PAXRecords: map[string]string{"SCHILY.xattr.user.origin": "vendor"},
+		}, body: "x"},
+	)
+	x, err := NewExtractor(context.Background(), dest, NewConfig(WithPreserveOwner(), WithXattrs()), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	if err := x.Extract(TarSource(tr)); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	name := filepath.Join(dest, "file")
+
+	if os.Geteuid() == 0 {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat := info.Sys().(*syscall.Stat_t); stat.Uid != 4321 || stat.Gid != 4321 {
+			t.Errorf("Test failed, expected: '%v', got:  '%v:%v'", "4321:4321", stat.Uid, stat.Gid)
+		}
+	}
+
+	buf := make([]byte, 64)
+	n, err := unix.Getxattr(name, "user.origin", buf)
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("no user xattrs on this file system")
+	}
+	if err != nil || string(buf[:n]) != "vendor" {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", "vendor", string(buf[:max(n, 0)]), err)
+	}
+}
@@ -0,0 +1,188 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+)
+
+// CloserFunc turns a function into an io.Closer.
+type CloserFunc func() error
+
+func (f CloserFunc) Close() error {
+	return f()
+}
+
+// OpenFunc starts a fresh pass over an archive.
+type OpenFunc func() (Source, io.Closer, error)
+
+type entryReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *entryReader) Close() error {
+	return r.closer.Close()
+}
+
+// OpenEntry scans the archive for the regular file name and returns its
+// content, read straight from the stream, without touching other members.
+// Symlinks and hard links are followed while they stay inside the archive.
+// open is called again only when a link leads to a member already passed.
+func OpenEntry(ctx context.Context, open OpenFunc, name string) (io.ReadCloser, error) {
+	want := fsName(name)
+	if want == "" || want == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for hops := 0; hops <= maxLinkHops; hops++ {
+		rc, next, err := scanEntry(ctx, open, want)
+		if err != nil {
+			if pathErr, ok := err.(*fs.PathError); ok {
+				pathErr.Path = name
+			}
+			return nil, err
+		}
+		if rc != nil {
+			return rc, nil
+		}
+		want = next
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("too many links")}
+}
+
+// scanEntry makes one pass looking for want. It returns either its content,
+// or the name to look for in a fresh pass.
+func scanEntry(ctx context.Context, open OpenFunc, want string) (io.ReadCloser, string, error) {
+	src, closer, err := open()
+	if err != nil {
+		return nil, "", err
+	}
+	keepOpen := false
+	defer func() {
+		if !keepOpen {
+			if err := closer.Close(); err != nil {
+				log.Errorf("Error closing archive: %v", err)
+			}
+		}
+	}()
+
+	seen := make(map[string]bool)
+	links := make(map[string]string) // symlink name -> target name
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		header, r, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Error reading archive: %v", err)
+			return nil, "", err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader || header.Typeflag == tar.TypeXHeader {
+			continue
+		}
+		name := fsName(header.Name)
+		if name == "" {
+			continue
+		}
+		seen[name] = true
+
+		redirected := false
+		switch {
+		case header.Typeflag == tar.TypeSymlink:
+			target := ""
+			if !path.IsAbs(header.Linkname) {
+				target = fsName(path.Join(path.Dir(name), header.Linkname))
+			}
+			links[name] = target
+			if name == want || strings.HasPrefix(want, name+"/") {
+				if target == "" {
+					return nil, "", &fs.PathError{Op: "open", Path: want, Err: ErrInsecurePath}
+				}
+				want = path.Join(target, strings.TrimPrefix(want, name))
+				redirected = true
+			}
+		case header.Typeflag == tar.TypeLink && name == want:
+			want = fsName(header.Linkname)
+			redirected = true
+		case name == want && header.Typeflag == tar.TypeReg:
+			keepOpen = true
+			return &entryReader{Reader: r, closer: closer}, "", nil
+		case name == want && header.Typeflag == tar.TypeDir:
+			return nil, "", &fs.PathError{Op: "open", Path: want, Err: errors.New("is a directory")}
+		case name == want:
+			return nil, "", &fs.PathError{Op: "open", Path: want, Err: errors.New("not a regular file")}
+		}
+
+		if redirected {
+			if want == "" {
+				return nil, "", &fs.PathError{Op: "open", Path: want, Err: ErrInsecurePath}
+			}
+			// Start over if the new name, or a symlink on its way, was passed already
+			if seen[want] || viaLink(want, links) {
+				return nil, want, nil
+			}
+		}
+	}
+	return nil, "", &fs.PathError{Op: "open", Path: want, Err: fs.ErrNotExist}
+}
+
+// viaLink reports whether a parent directory of name is a symlink in links.
+func viaLink(name string, links map[string]string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := links[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadEntry is OpenEntry followed by reading the whole content.
+func ReadEntry(ctx context.Context, open OpenFunc, name string) ([]byte, error) {
+	rc, err := OpenEntry(ctx, open, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rc io.ReadCloser) {
+		err := rc.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
+		}
+	}(rc)
+	return io.ReadAll(Reader(ctx, rc))
+}
+
+type fsFile struct {
+	fs.File
+	fsys *FS
+}
+
+func (f *fsFile) Close() error {
+	err := f.File.Close()
+	if err := f.fsys.Close(); err != nil {
+		return err
+	}
+	return err
+}
+
+// OpenFSEntry opens the regular file name of fsys. Closing it closes fsys too.
+func OpenFSEntry(fsys *FS, name string) (io.ReadCloser, error) {
+	f, err := fsys.Open(fsName(name))
+	if err != nil {
+		_ = fsys.Close()
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		_ = f.Close()
+		_ = fsys.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("not a regular file")}
+	}
+	return &fsFile{File: f, fsys: fsys}, nil
+}
@@ -0,0 +1,58 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+func countingOpen(t *testing.T, data []byte, passes *int) OpenFunc {
+	return func() (Source, io.Closer, error) {
+		*passes++
+		return TarSource(tar.NewReader(bytes.NewReader(data))), CloserFunc(func() error { return nil }), nil
+	}
+}
+
+func TestReadEntry(t *testing.T) {
+	entries := append([]testEntry{
+		{header: tar.Header{Name: "alias", Typeflag: tar.TypeSymlink, Linkname: "jdk/bin/java"}},
+	}, fsEntries()...)
+	data := tarBytes(t, entries...)
+
+	cases := []struct {
+		name     string
+		expected string
+		passes   int
+	}{
+		{"jdk/release", "JAVA_VERSION=21", 1},
+		{"./jdk/bin/java", "#!java", 1},
+		{"alias", "#!java", 1},                // links before their targets need one pass
+		{"jdk/current/java", "#!java", 2},     // the link comes after its target
+		{"jdk/RELEASE", "JAVA_VERSION=21", 2}, // hard links always point back
+	}
+	for _, c := range cases {
+		passes := 0
+		actual, err := ReadEntry(context.Background(), countingOpen(t, data, &passes), c.name)
+		if err != nil {
+			t.Errorf("Test failed for %s: %v", c.name, err)
+			continue
+		}
+		if string(actual) != c.expected || passes != c.passes {
+			t.Errorf("Test failed for %s, expected: '%v' in %d passes, got:  '%s' in %d", c.name, c.expected, c.passes, actual, passes)
+		}
+	}
+
+	passes := 0
+	_, err := ReadEntry(context.Background(), countingOpen(t, data, &passes), "jdk/missing")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", fs.ErrNotExist, err)
+	}
+	_, err = ReadEntry(context.Background(), countingOpen(t, data, &passes), "jdk")
+	if err == nil {
+		t.Errorf("Test failed, expected an error for a directory")
+	}
+}
@@ -0,0 +1,303 @@
+package archiver
+
+import (
+	"archive/zip"
+	"runtime"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Config collects the settings given to Compress and Extract as options.
+type Config struct {
+	Progress    ProgressFunc
+	ArchiveSize int64
+
+	// Include and Exclude select the entries Extract writes, see Match.
+	Include []string
+	Exclude []string
+
+	// StripComponents and Rename map archive paths to destination paths.
+	StripComponents int
+	Rename          func(name string) string
+
+	// Level is the deflate level, or the xz preset for txz,
+	// flate.DefaultCompression unless set.
+	// Concurrency is how many goroutines may compress at once; 0 leaves
+	// it to the format.
+	Level       int
+	Concurrency int
+
+	// ZstdEncoder and ZstdDecoder are passed on to zstd for tar.zst archives.
+	ZstdEncoder []zstd.EOption
+	ZstdDecoder []zstd.DOption
+
+	// FrameSize, if set, splits tar.zst output into frames of that many
+	// bytes that can be decompressed on their own.
+	FrameSize int
+
+	// Reproducible makes Compress write the same bytes for the same tree,
+	// see NormalizeHeader; SourceDateEpoch, if set, caps modification times.
+	Reproducible    bool
+	SourceDateEpoch time.Time
+
+	// PreserveOwner and Xattrs restore owners and extended attributes on
+	// extraction.
+	PreserveOwner bool
+	Xattrs        bool
+
+	// CopyLinks makes Extract copy hard-linked files it cannot link.
+	CopyLinks bool
+
+	// Manifest makes Compress add a manifest of checksums; CheckManifest
+	// makes Extract check what it wrote against it.
+	Manifest      bool
+	CheckManifest bool
+
+	// Atomic makes Extract write to a staging directory that is renamed
+	// to the destination once everything is in place.
+	Atomic bool
+
+	// Policy is what Extract does with paths that are taken, BackupSuffix
+	// what Backup appends; Changes, if set, is filled in with what it did.
+	Policy       Policy
+	BackupSuffix string
+	Changes      *Changes
+
+	// Sync makes Extract leave unchanged files alone and remove what the
+	// archive does not have.
+	Sync bool
+
+	// Method is the zip compression method, zip.Deflate unless set; Store
+	// adds extensions to the ones stored as they are, see MethodOf.
+	Method uint16
+	Store  []string
+
+	// Comment and EntryComment, if set, give the zip archive comment and
+	// the comment of each entry.
+	Comment      string
+	EntryComment func(name string) string
+}
+
+// Option changes one setting of a Config.
+type Option func(*Config)
+
+// NewConfig applies opts over the defaults.
+func NewConfig(opts ...Option) *Config {
+	cfg := &Config{ArchiveSize: -1, Level: flate.DefaultCompression, Method: zip.Deflate}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(cfg)
+		}
+	}
+	return cfg
+}
+
+// WithProgress reports progress to fn.
+func WithProgress(fn ProgressFunc) Option {
+	return func(c *Config) {
+		c.Progress = fn
+	}
+}
+
+// WithArchiveSize tells Extract how many bytes the archive has, so progress
+// can be reported against it. Extract sets it itself when reading a file.
+func WithArchiveSize(size int64) Option {
+	return func(c *Config) {
+		c.ArchiveSize = size
+	}
+}
+
+// WithInclude extracts only entries matching one of patterns.
+func WithInclude(patterns ...string) Option {
+	return func(c *Config) {
+		c.Include = append(c.Include, patterns...)
+	}
+}
+
+// WithExclude skips entries matching one of patterns.
+func WithExclude(patterns ...string) Option {
+	return func(c *Config) {
+		c.Exclude = append(c.Exclude, patterns...)
+	}
+}
+
+// WithStripComponents drops the first n elements of every entry path.
+func WithStripComponents(n int) Option {
+	return func(c *Config) {
+		c.StripComponents = n
+	}
+}
+
+// WithRename maps every entry path, after stripping, through fn.
+func WithRename(fn func(name string) string) Option {
+	return func(c *Config) {
+		c.Rename = fn
+	}
+}
+
+// WithLevel sets the compression level.
+func WithLevel(level int) Option {
+	return func(c *Config) {
+		c.Level = level
+	}
+}
+
+// WithConcurrency compresses on up to n goroutines, or on GOMAXPROCS
+// goroutines if n is below 1.
+func WithConcurrency(n int) Option {
+	return func(c *Config) {
+		if n < 1 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		c.Concurrency = n
+	}
+}
+
+// WithZstdEncoder adds opts to the zstd encoder.
+func WithZstdEncoder(opts ...zstd.EOption) Option {
+	return func(c *Config) {
+		c.ZstdEncoder = append(c.ZstdEncoder, opts...)
+	}
+}
+
+// WithZstdDecoder adds opts to the zstd decoder.
+func WithZstdDecoder(opts ...zstd.DOption) Option {
+	return func(c *Config) {
+		c.ZstdDecoder = append(c.ZstdDecoder, opts...)
+	}
+}
+
+// WithFrameSize writes tar.zst archives in frames of size bytes.
+func WithFrameSize(size int) Option {
+	return func(c *Config) {
+		c.FrameSize = size
+	}
+}
+
+// WithReproducible normalizes what Compress records, clamping times to the
+// SOURCE_DATE_EPOCH environment variable if it is set.
+func WithReproducible() Option {
+	return func(c *Config) {
+		c.Reproducible = true
+		if c.SourceDateEpoch.IsZero() {
+			c.SourceDateEpoch = SourceDateEpoch()
+		}
+	}
+}
+
+// WithSourceDateEpoch makes Compress reproducible, clamping times to t.
+func WithSourceDateEpoch(t time.Time) Option {
+	return func(c *Config) {
+		c.Reproducible = true
+		c.SourceDateEpoch = t
+	}
+}
+
+// WithPreserveOwner restores the owner and group of entries when running as root.
+func WithPreserveOwner() Option {
+	return func(c *Config) {
+		c.PreserveOwner = true
+	}
+}
+
+// WithXattrs restores extended attributes from PAX records.
+func WithXattrs() Option {
+	return func(c *Config) {
+		c.Xattrs = true
+	}
+}
+
+// WithCopyLinks copies hard-linked files where linking fails.
+func WithCopyLinks() Option {
+	return func(c *Config) {
+		c.CopyLinks = true
+	}
+}
+
+// WithManifest adds a manifest of the checksum, size and mode of every
+// regular file as the last entry of the archive.
+func WithManifest() Option {
+	return func(c *Config) {
+		c.Manifest = true
+	}
+}
+
+// WithCheckManifest makes Extract fail unless every file it writes matches
+// the manifest of the archive.
+func WithCheckManifest() Option {
+	return func(c *Config) {
+		c.CheckManifest = true
+	}
+}
+
+// WithAtomic makes Extract all or nothing.
+func WithAtomic() Option {
+	return func(c *Config) {
+		c.Atomic = true
+	}
+}
+
+// WithPolicy sets what Extract does with paths that are already taken.
+func WithPolicy(p Policy) Option {
+	return func(c *Config) {
+		c.Policy = p
+		if c.BackupSuffix == "" {
+			c.BackupSuffix = DefaultBackupSuffix
+		}
+	}
+}
+
+// WithBackup makes Extract rename what is in the way by appending suffix.
+func WithBackup(suffix string) Option {
+	return func(c *Config) {
+		if suffix == "" {
+			suffix = DefaultBackupSuffix
+		}
+		c.Policy = Backup
+		c.BackupSuffix = suffix
+	}
+}
+
+// WithChanges makes Extract record what it did in c.
+func WithChanges(c *Changes) Option {
+	return func(cfg *Config) {
+		cfg.Changes = c
+	}
+}
+
+// WithSync makes the destination match the archive.
+func WithSync() Option {
+	return func(c *Config) {
+		c.Sync = true
+	}
+}
+
+// WithMethod sets the zip compression method.
+func WithMethod(method uint16) Option {
+	return func(c *Config) {
+		c.Method = method
+	}
+}
+
+// WithStore stores zip entries with one of exts as they are.
+func WithStore(exts ...string) Option {
+	return func(c *Config) {
+		c.Store = append(c.Store, exts...)
+	}
+}
+
+// WithComment sets the zip archive comment.
+func WithComment(comment string) Option {
+	return func(c *Config) {
+		c.Comment = comment
+	}
+}
+
+// WithEntryComment gives each zip entry the comment fn returns for its name.
+func WithEntryComment(fn func(name string) string) Option {
+	return func(c *Config) {
+		c.EntryComment = fn
+	}
+}
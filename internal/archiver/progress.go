@@ -0,0 +1,127 @@
+package archiver
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Progress describes how far Compress or Extract has got.
+// Sizes that are not known are -1.
+type Progress struct {
+	Name         string // entry being processed
+	Entries      int    // entries started so far, including Name
+	EntryBytes   int64  // content bytes of Name done
+	EntrySize    int64  // content size of Name
+	Bytes        int64  // content bytes done over all entries
+	Total        int64  // content size of all entries
+	ArchiveBytes int64  // archive bytes read, when extracting
+	ArchiveSize  int64  // size of the archive, when extracting
+}
+
+// ProgressFunc is called on every entry and after every chunk of content.
+// It runs on the goroutine doing the work, so it should return quickly.
+type ProgressFunc func(Progress)
+
+// Meter turns entries and copied bytes into Progress events.
+// A nil *Meter is valid and reports nothing.
+type Meter struct {
+	fn ProgressFunc
+	p  Progress
+}
+
+// Meter returns a Meter reporting to c.Progress, or nil if there is none.
+// total is the content size of all entries, or -1.
+func (c *Config) Meter(total int64) *Meter {
+	if c.Progress == nil {
+		return nil
+	}
+	return &Meter{fn: c.Progress, p: Progress{
+		Total:       total,
+		EntrySize:   -1,
+		ArchiveSize: c.ArchiveSize,
+	}}
+}
+
+// Entry starts a new entry of size content bytes.
+func (m *Meter) Entry(name string, size int64) {
+	if m == nil {
+		return
+	}
+	m.p.Name = name
+	m.p.Entries++
+	m.p.EntryBytes = 0
+	m.p.EntrySize = size
+	m.fn(m.p)
+}
+
+// Writer counts the content bytes written through it.
+func (m *Meter) Writer(w io.Writer) io.Writer {
+	if m == nil {
+		return w
+	}
+	return &meterWriter{m: m, w: w}
+}
+
+// Reader counts the archive bytes read through it.
+func (m *Meter) Reader(r io.Reader) io.Reader {
+	if m == nil {
+		return r
+	}
+	return &meterReader{m: m, r: r}
+}
+
+type meterWriter struct {
+	m *Meter
+	w io.Writer
+}
+
+func (w *meterWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.m.p.EntryBytes += int64(n)
+		w.m.p.Bytes += int64(n)
+		w.m.fn(w.m.p)
+	}
+	return n, err
+}
+
+type meterReader struct {
+	m *Meter
+	r io.Reader
+}
+
+func (r *meterReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.m.p.ArchiveBytes += int64(n)
+	return n, err
+}
+
+// TotalSize sums the sizes of the regular files under files, for Progress.Total.
+func TotalSize(files []string) int64 {
+	var total int64
+	seen := map[fileKey]bool{}
+	for _, src := range files {
+		err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			// Further names of a file are stored as links, without content
+			if key, ok := hardLinked(info); ok {
+				if seen[key] {
+					return nil
+				}
+				seen[key] = true
+			}
+			total += info.Size()
+			return nil
+		})
+		if err != nil {
+			return -1
+		}
+	}
+	return total
+}
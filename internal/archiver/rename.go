@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"archive/tar"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MapName turns an archive path into the path it is extracted to, applying
+// StripComponents and then Rename. It reports false if the entry is dropped,
+// because it was stripped away or Rename returned "".
+func (c *Config) MapName(name string) (string, bool) {
+	if c.StripComponents == 0 && c.Rename == nil {
+		return name, true
+	}
+	dir := strings.HasSuffix(name, "/")
+	clean := path.Clean(name)
+	// Absolute names are left alone for the security check to reject
+	if c.StripComponents > 0 && !path.IsAbs(clean) {
+		elems := strings.Split(clean, "/")
+		if len(elems) <= c.StripComponents {
+			return "", false
+		}
+		clean = strings.Join(elems[c.StripComponents:], "/")
+	}
+	if c.Rename != nil {
+		clean = c.Rename(clean)
+		if clean == "" {
+			return "", false
+		}
+	}
+	if dir {
+		clean += "/"
+	}
+	return clean, true
+}
+
+// mapTarget is MapName for a link target, where the stripped directory
+// itself is a valid answer and stands for the destination root.
+func (c *Config) mapTarget(target string) (string, bool) {
+	if c.StripComponents > 0 && len(strings.Split(target, "/")) == c.StripComponents {
+		return ".", true
+	}
+	return c.MapName(target)
+}
+
+// mapHeader renames the entry of header, and its link target to match.
+// It reports false if the entry is dropped.
+func (c *Config) mapHeader(header *tar.Header) (*tar.Header, bool) {
+	if c.StripComponents == 0 && c.Rename == nil {
+		return header, true
+	}
+	name, ok := c.MapName(header.Name)
+	if !ok {
+		return nil, false
+	}
+	mapped := *header
+	mapped.Name = name
+
+	switch header.Typeflag {
+	case tar.TypeLink:
+		target, ok := c.MapName(header.Linkname)
+		if !ok {
+			return nil, false
+		}
+		mapped.Linkname = target
+	case tar.TypeSymlink:
+		if path.IsAbs(header.Linkname) {
+			break
+		}
+		// Resolve the target in the archive, map it, and point at it again
+		// from where the link ends up
+		target := path.Join(path.Dir(path.Clean(header.Name)), header.Linkname)
+		if target == ".." || strings.HasPrefix(target, "../") {
+			break
+		}
+		target, ok := c.mapTarget(target)
+		if !ok {
+			break
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(path.Dir(path.Clean(name))), filepath.FromSlash(path.Clean(target)))
+		if err != nil {
+			break
+		}
+		mapped.Linkname = filepath.ToSlash(rel)
+	}
+	return &mapped, true
+}
@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractStripAndRename(t *testing.T) {
+	dest := t.TempDir()
+	tr := tarOf(t,
+		testEntry{header: tar.Header{Name: "jdk-21/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		testEntry{header: tar.Header{Name: "jdk-21/bin/java", Typeflag: tar.TypeReg, Mode: 0o755}, body: "java"},
+		testEntry{header: tar.Header{Name: "jdk-21/release", Typeflag: tar.TypeReg, Mode: 0o644}, body: "21"},
+		testEntry{header: tar.Header{Name: "jdk-21/lib/java", Typeflag: tar.TypeSymlink, Linkname: "../bin/java"}},
+		testEntry{header: tar.Header{Name: "jdk-21/home", Typeflag: tar.TypeSymlink, Linkname: "."}},
+		testEntry{header: tar.Header{Name: "jdk-21/docs/release", Typeflag: tar.TypeSymlink, Linkname: "../release"}},
+		testEntry{header: tar.Header{Name: "jdk-21/java", Typeflag: tar.TypeLink, Linkname: "jdk-21/bin/java"}},
+	)
+	rename := func(name string) string {
+		if strings.HasPrefix(name, "docs") {
+			return ""
+		}
+		return strings.Replace(name, "bin/", "tools/", 1)
+	}
+	x, err := NewExtractor(context.Background(), dest, NewConfig(WithStripComponents(1), WithRename(rename)), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	if err := x.Extract(TarSource(tr)); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	for name, want := range map[string]string{
+		"tools/java":   "java",
+		"release":      "21",
+		"lib/java":     "java",
+		"home/release": "21",
+		"java":         "java",
+	} {
+		data, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(data) != want {
+			t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", want, string(data), err)
+		}
+	}
+	if link, _ := os.Readlink(filepath.Join(dest, "lib/java")); link != "../tools/java" {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", "../tools/java", link)
+	}
+	for _, name := range []string{"jdk-21", "bin", "docs"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); !os.IsNotExist(err) {
+			t.Errorf("Test failed, %s should not exist", name)
+		}
+	}
+}
+
+func TestExtractRenameStaysInside(t *testing.T) {
+	tr := tarOf(t, testEntry{header: tar.Header{Name: "a/file", Typeflag: tar.TypeReg}, body: "x"})
+	x, err := NewExtractor(context.Background(), t.TempDir(),
+		NewConfig(WithRename(func(name string) string { return "../" + name })), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	if err := x.Extract(TarSource(tr)); !errors.Is(err, ErrInsecurePath) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrInsecurePath, err)
+	}
+}
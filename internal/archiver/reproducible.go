@@ -0,0 +1,77 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SourceDateEpoch returns the time in the SOURCE_DATE_EPOCH environment
+// variable, see https://reproducible-builds.org/specs/source-date-epoch/,
+// or the zero time if it is not set or not valid.
+func SourceDateEpoch() time.Time {
+	sec, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// ModTime is t as it goes into a reproducible archive: whole seconds in UTC,
+// and no later than SourceDateEpoch if that is set.
+func (c *Config) ModTime(t time.Time) time.Time {
+	if !c.SourceDateEpoch.IsZero() && t.After(c.SourceDateEpoch) {
+		t = c.SourceDateEpoch
+	}
+	return t.Truncate(time.Second).UTC()
+}
+
+// Perm is the permission a reproducible archive records: 0777 for symlinks,
+// 0755 for directories and files executable by anyone, 0644 for the rest.
+func Perm(mode fs.FileMode) fs.FileMode {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return 0o777
+	case mode.IsDir(), mode&0o111 != 0:
+		return 0o755
+	default:
+		return 0o644
+	}
+}
+
+// NormalizeHeader strips header of what differs between hosts and checkouts
+// if the archive is to be reproducible: owner, group, permission bits beyond
+// Perm, access and change times, and modification times past
+// SourceDateEpoch.
+func (c *Config) NormalizeHeader(header *tar.Header) {
+	if !c.Reproducible {
+		return
+	}
+	mode := fs.FileMode(header.Mode).Perm()
+	switch header.Typeflag {
+	case tar.TypeDir:
+		mode |= fs.ModeDir
+	case tar.TypeSymlink:
+		mode |= fs.ModeSymlink
+	}
+	header.Mode = int64(Perm(mode))
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.ModTime = c.ModTime(header.ModTime)
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.Format = tar.FormatUnknown
+}
+
+// NormalizeZipHeader is NormalizeHeader for zip archives, which also records
+// the time in UTC rather than in the local time zone.
+func (c *Config) NormalizeZipHeader(header *zip.FileHeader) {
+	if !c.Reproducible {
+		return
+	}
+	mode := header.Mode()
+	header.SetMode(mode.Type() | Perm(mode))
+	header.Modified = c.ModTime(header.Modified)
+}
@@ -0,0 +1,108 @@
+package archiver
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/gommon/log"
+)
+
+// keep records name and the directories above it as part of the archive,
+// so that prune leaves them.
+func (x *Extractor) keep(name string) {
+	for ; name != "." && name != string(filepath.Separator) && !x.kept[name]; name = filepath.Dir(name) {
+		x.kept[name] = true
+	}
+}
+
+// unchanged reports whether what is at name already is the entry header,
+// for WithSync. Like rsync, it takes a regular file of the same size and
+// modification time for unchanged, without reading it; only its mode is
+// brought up to date if that differs.
+func (x *Extractor) unchanged(name string, header *tar.Header) bool {
+	info, err := x.root.Lstat(name)
+	if err != nil {
+		return false
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		if !info.Mode().IsRegular() || info.Size() != header.Size || !info.ModTime().Equal(header.ModTime) {
+			return false
+		}
+		if perm := header.FileInfo().Mode().Perm(); info.Mode().Perm() != perm {
+			if err := x.root.Chmod(name, perm); err != nil {
+				log.Warnf("Could not set file mode: %v", err)
+				return false
+			}
+			x.changes.Updated = append(x.changes.Updated, filepath.ToSlash(name))
+		}
+	case tar.TypeSymlink:
+		if info.Mode().Type() != fs.ModeSymlink {
+			return false
+		}
+		if target, err := x.root.Readlink(name); err != nil || target != header.Linkname {
+			return false
+		}
+	case tar.TypeLink:
+		target, err := LocalName(header.Linkname)
+		if err != nil {
+			return false
+		}
+		if targetInfo, err := x.root.Lstat(target); err != nil || !os.SameFile(info, targetInfo) {
+			return false
+		}
+	default:
+		return false
+	}
+	x.written[name] = true
+	return true
+}
+
+// skipUnchanged is unchanged for an entry whose content is in r, which is
+// drained if a manifest check needs its checksum.
+func (x *Extractor) skipUnchanged(name string, header *tar.Header, r io.Reader) (bool, error) {
+	if !x.cfg.Sync || !x.unchanged(name, header) {
+		return false, nil
+	}
+	if x.sums != nil {
+		if _, err := Copy(x.ctx, io.Discard, r); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// prune removes everything under the destination that the archive did not
+// have, for WithSync.
+func (x *Extractor) prune() error {
+	var stale []string
+	err := fs.WalkDir(x.root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(p)
+		if p == "." || x.kept[name] {
+			return nil
+		}
+		stale = append(stale, name)
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error walking destination: %v", err)
+		return err
+	}
+	for _, name := range stale {
+		if err := x.root.RemoveAll(name); err != nil {
+			log.Errorf("Error removing stale file: %v", err)
+			return err
+		}
+		x.changes.Removed = append(x.changes.Removed, filepath.ToSlash(name))
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+)
+
+// Sink takes the entries of an archive being written, in order. The reader
+// of a regular file holds its content.
+type Sink interface {
+	WriteEntry(header *tar.Header, r io.Reader) error
+}
+
+type tarSink struct {
+	tw  *tar.Writer
+	cfg *Config
+}
+
+// TarSink writes entries to tw as they are, normalized if the archive is
+// to be reproducible.
+func TarSink(tw *tar.Writer, cfg *Config) Sink {
+	return tarSink{tw: tw, cfg: cfg}
+}
+
+func (s tarSink) WriteEntry(header *tar.Header, r io.Reader) error {
+	s.cfg.NormalizeHeader(header)
+	if err := s.tw.WriteHeader(header); err != nil {
+		log.Errorf("Error writing header: %v", err)
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	_, err := io.Copy(s.tw, r)
+	return err
+}
+
+type zipSink struct {
+	zw  *zip.Writer
+	cfg *Config
+	out *os.File
+
+	// written holds the header and data offset in out of the files and
+	// hard links written so far, for the hard links that follow.
+	written map[string]zipData
+}
+
+type zipData struct {
+	header *zip.FileHeader
+	offset int64
+}
+
+// ZipSink writes entries to zw, symlinks with their target as content the
+// way zip keeps them. Zip has no hard links, so the compressed data of their
+// target is copied, read back from out, the file zw writes to. Entries zip
+// can't hold, such as devices, are skipped.
+func ZipSink(zw *zip.Writer, cfg *Config, out *os.File) Sink {
+	return &zipSink{zw: zw, cfg: cfg, out: out, written: map[string]zipData{}}
+}
+
+func (s *zipSink) WriteEntry(header *tar.Header, r io.Reader) error {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+	default:
+		log.Debugf("Skipping entry zip can't hold: %s (type: %c)", header.Name, header.Typeflag)
+		return nil
+	}
+	fh, err := zip.FileInfoHeader(header.FileInfo())
+	if err != nil {
+		log.Errorf("Error creating header: %v", err)
+		return err
+	}
+	fh.Name = header.Name
+	if header.Typeflag == tar.TypeDir && !strings.HasSuffix(fh.Name, "/") {
+		fh.Name += "/"
+	}
+	fh.Method = s.cfg.MethodOf(fh.Name)
+	fh.Modified = header.ModTime
+	fh.Comment = header.PAXRecords["comment"]
+	if comment := s.cfg.CommentOf(fh.Name); comment != "" {
+		fh.Comment = comment
+	}
+	s.cfg.NormalizeZipHeader(fh)
+	if header.Typeflag == tar.TypeLink {
+		return s.writeLink(header, fh)
+	}
+	w, err := s.zw.CreateHeader(fh)
+	if err != nil {
+		log.Errorf("Error creating header: %v", err)
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg:
+		if err := s.record(header.Name, fh); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+	case tar.TypeSymlink:
+		_, err = io.WriteString(w, header.Linkname)
+	}
+	return err
+}
+
+// writeLink writes the hard link header as a copy of the data of its
+// target, as compressed already.
+func (s *zipSink) writeLink(header *tar.Header, fh *zip.FileHeader) error {
+	target, ok := s.written[path.Clean(header.Linkname)]
+	if !ok {
+		log.Errorf("Hard link target does not exist: %s", header.Linkname)
+		return fmt.Errorf("hard link target does not exist: %s", header.Linkname)
+	}
+	fh.Method = target.header.Method
+	// The sizes go in a data descriptor, as the target may still be open
+	// until CreateRaw closes it
+	fh.Flags |= 0x8
+	SetExtendedTime(fh)
+	w, err := s.zw.CreateRaw(fh)
+	if err != nil {
+		log.Errorf("Error creating header: %v", err)
+		return err
+	}
+	fh.CRC32 = target.header.CRC32
+	fh.CompressedSize, fh.CompressedSize64 = target.header.CompressedSize, target.header.CompressedSize64
+	fh.UncompressedSize, fh.UncompressedSize64 = target.header.UncompressedSize, target.header.UncompressedSize64
+	fh.ReaderVersion = target.header.ReaderVersion
+	if err := s.record(header.Name, fh); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.NewSectionReader(s.out, target.offset, int64(fh.CompressedSize64)))
+	return err
+}
+
+// record remembers where the data of the entry just created for name
+// starts in out.
+func (s *zipSink) record(name string, fh *zip.FileHeader) error {
+	if err := s.zw.Flush(); err != nil {
+		log.Errorf("Error flushing zip: %v", err)
+		return err
+	}
+	offset, err := s.out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking archive: %v", err)
+		return err
+	}
+	s.written[path.Clean(name)] = zipData{header: fh, offset: offset}
+	return nil
+}
+
+// Transcode copies every entry of src to dst without touching the disk,
+// converting headers to what dst can hold.
+func Transcode(ctx context.Context, src Source, dst Sink, meter *Meter) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, r, err := src.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			log.Errorf("Error reading archive: %v", err)
+			return err
+		}
+		meter.Entry(header.Name, header.Size)
+		if err := dst.WriteEntry(header, io.TeeReader(Reader(ctx, r), meter.Writer(io.Discard))); err != nil {
+			return err
+		}
+	}
+}
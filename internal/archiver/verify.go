@@ -0,0 +1,191 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+)
+
+// Problem is something wrong with an archive, found by Verify.
+type Problem struct {
+	// Entry is the entry being read, or "" for the archive as a whole,
+	// such as a bad trailer.
+	Entry string
+	Err   error
+}
+
+func (p Problem) Error() string {
+	if p.Entry == "" {
+		return p.Err.Error()
+	}
+	return p.Entry + ": " + p.Err.Error()
+}
+
+func (p Problem) Unwrap() error {
+	return p.Err
+}
+
+// Report is what Verify found. Problems are in archive order; a problem
+// with the compressed stream, such as truncation, ends the report, since
+// nothing after it can be read.
+type Report struct {
+	Entries  int
+	Bytes    int64
+	Problems []Problem
+}
+
+// OK reports whether no problems were found.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Err returns the problems as one error, or nil if there are none.
+func (r *Report) Err() error {
+	errs := make([]error, len(r.Problems))
+	for i, p := range r.Problems {
+		errs[i] = p
+	}
+	return errors.Join(errs...)
+}
+
+// Add records err against entry.
+func (r *Report) Add(entry string, err error) {
+	r.Problems = append(r.Problems, Problem{Entry: entry, Err: err})
+}
+
+var (
+	errEmptyName     = errors.New("empty name")
+	errBadSize       = errors.New("bad size")
+	errBadType       = errors.New("unknown entry type")
+	errMissingTarget = errors.New("hard link to an entry that is not before it")
+)
+
+// checkHeader reports what would make Extract refuse or misplace header.
+// files lists the regular files seen so far, for hard link targets.
+func checkHeader(header *tar.Header, files map[string]bool) error {
+	name := path.Clean(header.Name)
+	switch {
+	case header.Name == "":
+		return errEmptyName
+	case header.Size < 0:
+		return errBadSize
+	}
+	if _, err := LocalName(header.Name); err != nil {
+		return err
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		files[name] = true
+	case tar.TypeSymlink:
+		return checkSymlink(name, header.Linkname)
+	case tar.TypeLink:
+		if _, err := LocalName(header.Linkname); err != nil {
+			return err
+		}
+		if !files[path.Clean(header.Linkname)] {
+			return fmt.Errorf("%w: %s", errMissingTarget, header.Linkname)
+		}
+		files[name] = true
+	case tar.TypeDir, tar.TypeChar, tar.TypeBlock, tar.TypeFifo, tar.TypeCont,
+		tar.TypeGNUSparse, tar.TypeXGlobalHeader:
+	default:
+		return fmt.Errorf("%w: %c", errBadType, header.Typeflag)
+	}
+	return nil
+}
+
+// VerifySource reads every entry of src to the end without writing
+// anything, adding what is wrong to report. Checksums are checked by the
+// readers under src as the content goes through them, and against the
+// manifest if the archive has one.
+func VerifySource(ctx context.Context, src Source, meter *Meter, report *Report) error {
+	files := map[string]bool{}
+	sums := newManifestSums()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, r, err := src.Next()
+		if err == io.EOF {
+			sums.report(report)
+			return nil
+		} else if err != nil {
+			report.Add("", err)
+			return nil
+		}
+		report.Entries++
+		meter.Entry(header.Name, header.Size)
+		if err := checkHeader(header, files); err != nil {
+			report.Add(header.Name, err)
+		}
+		r, done, err := sums.read(header, r)
+		if err != nil {
+			report.Add(header.Name, err)
+			continue
+		}
+		n, err := Copy(ctx, meter.Writer(io.Discard), r)
+		report.Bytes += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			report.Add(header.Name, err)
+			return nil
+		}
+		done()
+	}
+}
+
+// VerifyZip is VerifySource for zip archives, whose entries are checked
+// independently, so one corrupt entry doesn't hide the rest.
+func VerifyZip(ctx context.Context, files []*zip.File, meter *Meter, report *Report) error {
+	names := map[string]bool{}
+	sums := newManifestSums()
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Entries++
+		header := ZipHeader(f)
+		meter.Entry(header.Name, header.Size)
+
+		rc, err := f.Open()
+		if err != nil {
+			report.Add(f.Name, err)
+			continue
+		}
+		r, done, err := sums.read(header, rc)
+		if err != nil {
+			_ = rc.Close()
+			report.Add(f.Name, err)
+			continue
+		}
+		var content bytes.Buffer
+		w := meter.Writer(io.Discard)
+		if header.Typeflag == tar.TypeSymlink {
+			w = &content
+		}
+		n, err := Copy(ctx, w, r)
+		_ = rc.Close()
+		report.Bytes += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			report.Add(f.Name, err)
+			continue
+		}
+		done()
+		header.Linkname = content.String()
+		if err := checkHeader(header, names); err != nil {
+			report.Add(f.Name, err)
+		}
+	}
+	sums.report(report)
+	return nil
+}
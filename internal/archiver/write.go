@@ -0,0 +1,114 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+)
+
+// WriteTar writes files to tw, directories with everything below them
+// under their base name, the way Compress lays archives out: hard links as
+// links to the first name, the manifest last if asked for. tw is not closed.
+func WriteTar(ctx context.Context, tw *tar.Writer, files []string, cfg *Config, meter *Meter) error {
+	links := NewHardLinks()
+	manifest := cfg.NewManifest()
+	for _, src := range files {
+		info, err := os.Stat(src)
+		if err != nil {
+			log.Errorf("Error stating files: %v", err)
+			return err
+		}
+		var baseDir string
+		if info.IsDir() {
+			baseDir = filepath.Base(src)
+		}
+
+		err = filepath.Walk(src,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					log.Errorf("Error walking path: %v", err)
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				link := ""
+				if info.Mode()&os.ModeSymlink != 0 {
+					link, err = os.Readlink(path)
+					if err != nil {
+						log.Errorf("Error reading symlink: %v", err)
+						return err
+					}
+				}
+				header, err := tar.FileInfoHeader(info, link)
+				if err != nil {
+					log.Errorf("Error creating tar header: %v", err)
+					return err
+				}
+				header.Name = StoredName(path)
+				if baseDir != "" {
+					header.Name = filepath.ToSlash(filepath.Join(baseDir, strings.TrimPrefix(path, src)))
+				}
+				if info.IsDir() {
+					header.Name += "/"
+				}
+				links.Link(header, info)
+
+				cfg.NormalizeHeader(header)
+				if err := tw.WriteHeader(header); err != nil {
+					log.Errorf("Error writing header: %v", err)
+					return err
+				}
+				meter.Entry(header.Name, header.Size)
+				if header.Typeflag != tar.TypeReg {
+					manifest.AddHeader(header)
+					return nil
+				}
+
+				file, err := os.Open(path)
+				if err != nil {
+					log.Errorf("Error opening file: %v", err)
+					return err
+				}
+				defer func(file *os.File) {
+					err := file.Close()
+					if err != nil {
+						log.Errorf("Error closing file: %v", err)
+					}
+				}(file)
+
+				_, err = Copy(ctx, manifest.Writer(meter.Writer(tw)), file)
+				if err != nil {
+					log.Errorf("Error copying file data: %v %s", err, path)
+					return err
+				}
+				manifest.AddHeader(header)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+	if err := manifest.WriteTar(tw, cfg); err != nil {
+		log.Errorf("Error writing manifest: %v", err)
+		return err
+	}
+	return nil
+}
+
+// StoredName is the file name as an archive stores it: slash-separated,
+// without a volume name, leading slashes or leading ".." elements, as GNU
+// tar does, so that it extracts below the destination.
+func StoredName(name string) string {
+	name = path.Clean(filepath.ToSlash(strings.TrimPrefix(name, filepath.VolumeName(name))))
+	for strings.HasPrefix(name, "../") {
+		name = name[len("../"):]
+	}
+	return strings.TrimLeft(name, "/")
+}
@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestWriteTarRoundTrip(t *testing.T) {
+	compressors := map[string]struct {
+		writer func(w io.Writer) (io.WriteCloser, error)
+		reader func(r io.Reader) (io.Reader, error)
+	}{
+		"gzip": {
+			writer: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+			reader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		"zstd": {
+			writer: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+			reader: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		},
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range compressors {
+		var buf bytes.Buffer
+		w, err := c.writer(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := NewConfig()
+		tw := tar.NewWriter(w)
+		if err := WriteTar(context.Background(), tw, []string{src}, cfg, cfg.Meter(-1)); err != nil {
+			t.Fatalf("Test failed for %s, expected: '%v', got:  '%v'", name, nil, err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := c.reader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out-"+name)
+		if err := extract(t, context.Background(), out, tar.NewReader(r)); err != nil {
+			t.Fatalf("Test failed for %s, expected: '%v', got:  '%v'", name, nil, err)
+		}
+		if data, err := os.ReadFile(filepath.Join(out, "src", "a.txt")); err != nil || string(data) != "hello" {
+			t.Errorf("Test failed for %s, expected: 'hello', got:  '%s' (%v)", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package archiver
+
+import (
+	"errors"
+	"os"
+)
+
+func setXattr(*os.File, string, []byte) error {
+	return errors.ErrUnsupported
+}
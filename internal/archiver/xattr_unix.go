@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package archiver
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func setXattr(f *os.File, name string, value []byte) error {
+	return unix.Fsetxattr(int(f.Fd()), name, value, 0)
+}
@@ -0,0 +1,211 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/gommon/log"
+)
+
+// ZipZstd is the zip method number WinZip and 7-Zip use for zstd.
+const ZipZstd = zstd.ZipMethodWinZip
+
+// extTimeExtraID is the Info-ZIP extended timestamp extra field.
+const extTimeExtraID = 0x5455
+
+// storeExtensions are files that are compressed already, which MethodOf
+// stores rather than compress again.
+var storeExtensions = []string{
+	".zip", ".jar", ".war", ".ear", ".apk", ".whl", ".nupkg", ".npz", ".pt", ".pth",
+	".gz", ".tgz", ".bz2", ".tbz2", ".xz", ".txz", ".zst", ".tzst", ".lz4", ".7z", ".rar",
+	".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".heic",
+	".mp3", ".mp4", ".m4a", ".mov", ".mkv", ".webm", ".ogg", ".flac",
+	".woff", ".woff2", ".safetensors", ".gguf",
+}
+
+type zipSource struct {
+	files []*zip.File
+	rc    io.ReadCloser
+}
+
+// ZipSource hands the entries of a zip archive out as tar headers, with
+// symlink targets, which zip stores as content, moved into Linkname.
+func ZipSource(files []*zip.File) Source {
+	return &zipSource{files: files}
+}
+
+func (s *zipSource) Next() (*tar.Header, io.Reader, error) {
+	if s.rc != nil {
+		_ = s.rc.Close()
+		s.rc = nil
+	}
+	if len(s.files) == 0 {
+		return nil, nil, io.EOF
+	}
+	f := s.files[0]
+	s.files = s.files[1:]
+
+	header := ZipHeader(f)
+	if header.Typeflag != tar.TypeSymlink {
+		r := &lazyReader{f: f}
+		s.rc = r
+		return header, r, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	buf := new(bytes.Buffer)
+	_, err = io.Copy(buf, rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	header.Linkname = buf.String()
+	return header, bytes.NewReader(nil), nil
+}
+
+// lazyReader opens f on the first Read, so that entries that are only
+// listed are not decompressed.
+type lazyReader struct {
+	f  *zip.File
+	rc io.ReadCloser
+}
+
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if r.rc == nil {
+		rc, err := r.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	return r.rc.Read(p)
+}
+
+func (r *lazyReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	return r.rc.Close()
+}
+
+// ZipHeader describes f as a tar header, with its comment as a PAX comment
+// record. The Linkname of a symlink is left empty, because zip keeps it in
+// the content.
+func ZipHeader(f *zip.File) *tar.Header {
+	info := f.FileInfo()
+	header := &tar.Header{
+		Name:    f.Name,
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: f.Modified,
+	}
+	if f.Comment != "" {
+		header.PAXRecords = map[string]string{"comment": f.Comment}
+	}
+	switch {
+	case info.IsDir():
+		header.Typeflag = tar.TypeDir
+		if !strings.HasSuffix(header.Name, "/") {
+			header.Name += "/"
+		}
+	case info.Mode().Type() == fs.ModeSymlink:
+		header.Typeflag = tar.TypeSymlink
+	default:
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(f.UncompressedSize64)
+	}
+	return header
+}
+
+// OpenZip opens the zip archive name, able to read zstd entries.
+func OpenZip(name string) (*zip.ReadCloser, error) {
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	registerZstd(&archive.Reader)
+	return archive, nil
+}
+
+// NewZipReader reads the zip archive in r, able to read zstd entries.
+func NewZipReader(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	registerZstd(archive)
+	return archive, nil
+}
+
+func registerZstd(archive *zip.Reader) {
+	archive.RegisterDecompressor(ZipZstd, zstd.ZipDecompressor())
+	archive.RegisterDecompressor(zstd.ZipMethodPKWare, zstd.ZipDecompressor())
+}
+
+// NewZipWriter returns a zip.Writer to w that can write zstd entries, with
+// the archive comment of cfg.
+func NewZipWriter(w io.Writer, cfg *Config) (*zip.Writer, error) {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(ZipZstd, zstd.ZipCompressor(cfg.ZstdEncoder...))
+	if cfg.Comment != "" {
+		if err := zw.SetComment(cfg.Comment); err != nil {
+			log.Errorf("Error setting comment: %v", err)
+			return nil, err
+		}
+	}
+	return zw, nil
+}
+
+// MethodOf picks the zip method for the entry name: zip.Store for
+// extensions that are compressed already or were added by WithStore, the
+// configured method otherwise.
+func (c *Config) MethodOf(name string) uint16 {
+	ext := strings.ToLower(path.Ext(name))
+	if ext != "" && (slices.Contains(storeExtensions, ext) || slices.ContainsFunc(c.Store, func(s string) bool {
+		return strings.EqualFold(s, ext) || strings.EqualFold("."+s, ext)
+	})) {
+		return zip.Store
+	}
+	return c.Method
+}
+
+// CommentOf returns the comment WithEntryComment gives the entry name.
+func (c *Config) CommentOf(name string) string {
+	if c.EntryComment == nil {
+		return ""
+	}
+	return c.EntryComment(name)
+}
+
+// SetExtendedTime records header.Modified the way zip.Writer.CreateHeader
+// does, as an MS-DOS time and in an Info-ZIP extended timestamp extra
+// field, for entries written with CreateRaw, which leaves both to the caller.
+func SetExtendedTime(header *zip.FileHeader) {
+	if header.Modified.IsZero() {
+		return
+	}
+	header.ModifiedDate, header.ModifiedTime = msDosTime(header.Modified)
+	field := make([]byte, 9)
+	binary.LittleEndian.PutUint16(field, extTimeExtraID)
+	binary.LittleEndian.PutUint16(field[2:], 5)
+	field[4] = 1 // modification time only, as the central directory holds no more
+	binary.LittleEndian.PutUint32(field[5:], uint32(header.Modified.Unix()))
+	header.Extra = append(header.Extra, field...)
+}
+
+// msDosTime is the MS-DOS date and time of t, in its own time zone.
+func msDosTime(t time.Time) (date, clock uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
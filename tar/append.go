@@ -0,0 +1,194 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+const blockSize = 512
+
+// ErrAppendManifest is returned by Append with WithManifest: a manifest
+// only describes the archive it was written with.
+var ErrAppendManifest = errors.New("can't append a manifest to an archive")
+
+// Append adds files to the end of the tar archive tarName, creating it if
+// it does not exist, by writing over its end-of-archive marker. The entries
+// already there are not rewritten; an appended entry with the same name as
+// one of them replaces it on extraction, as with tar -r.
+func Append(tarName string, files ...string) error {
+	return AppendWithOptions(context.Background(), tarName, files)
+}
+
+// AppendContext is Append that stops once ctx is done, leaving the archive
+// as it was.
+func AppendContext(ctx context.Context, tarName string, files ...string) error {
+	return AppendWithOptions(ctx, tarName, files)
+}
+
+// AppendWithOptions is AppendContext configured by opts.
+func AppendWithOptions(ctx context.Context, tarName string, files []string, opts ...Option) (err error) {
+	cfg := archiver.NewConfig(opts...)
+	if cfg.Manifest {
+		log.Errorf("Error appending to %s: %v", tarName, ErrAppendManifest)
+		return ErrAppendManifest
+	}
+	total := int64(-1)
+	if cfg.Progress != nil {
+		total = archiver.TotalSize(files)
+	}
+	meter := cfg.Meter(total)
+
+	_, statErr := os.Stat(tarName)
+	created := os.IsNotExist(statErr)
+	file, err := os.OpenFile(tarName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Errorf("Error opening archive: %v", err)
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
+		}
+	}(file)
+
+	end, err := endOfArchive(file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		log.Errorf("Error seeking archive: %v", err)
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if created {
+			if err := os.Remove(tarName); err != nil {
+				log.Errorf("Error removing archive: %v", err)
+			}
+		} else if err := restoreEnd(file, end); err != nil {
+			log.Errorf("Error restoring archive: %v", err)
+		}
+	}()
+
+	tarWriter := tar.NewWriter(file)
+	if err := archiver.WriteTar(ctx, tarWriter, files, cfg, meter); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		log.Errorf("Error closing tar: %v", err)
+		return err
+	}
+	// Drop what followed the old marker, such as the padding of the last record
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking archive: %v", err)
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		log.Errorf("Error truncating archive: %v", err)
+		return err
+	}
+	return nil
+}
+
+// endOfArchive returns the offset of the end-of-archive marker in file,
+// or of the end of its last entry where the marker is missing.
+func endOfArchive(file *os.File) (int64, error) {
+	tarReader := tar.NewReader(file)
+	var dataEnd int64
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Error reading tar: %v", err)
+			return 0, err
+		}
+		size := header.Size
+		switch {
+		case headerOnly(header.Typeflag):
+			size = 0
+		case sparse(header):
+			// Size is that of the expanded file, so read through what is
+			// stored to find where it ends
+			if _, err := io.Copy(io.Discard, tarReader); err != nil {
+				log.Errorf("Error reading tar: %v", err)
+				return 0, err
+			}
+			size = 0
+		}
+		pos, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			log.Errorf("Error seeking archive: %v", err)
+			return 0, err
+		}
+		dataEnd = (pos + size + blockSize - 1) / blockSize * blockSize
+	}
+	end, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking archive: %v", err)
+		return 0, err
+	}
+
+	// Next stops after the two zero blocks of the marker, or at the end of
+	// the file if they are missing or cut short; zero blocks before dataEnd
+	// are content of the last entry
+	block := make([]byte, blockSize)
+	for i := 0; i < 2 && end-blockSize >= dataEnd; i++ {
+		if _, err := file.ReadAt(block, end-blockSize); err != nil {
+			log.Errorf("Error reading tar: %v", err)
+			return 0, err
+		}
+		if !bytes.Equal(block, make([]byte, blockSize)) {
+			break
+		}
+		end -= blockSize
+	}
+	return end, nil
+}
+
+// headerOnly reports whether entries of type flag have no content, whatever
+// their size says.
+func headerOnly(flag byte) bool {
+	switch flag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return true
+	default:
+		return false
+	}
+}
+
+// sparse reports whether header is a GNU sparse file, whose stored data is
+// shorter than its size.
+func sparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreEnd cuts file back to end and writes the end-of-archive marker
+// there again.
+func restoreEnd(file *os.File, end int64) error {
+	if err := file.Truncate(end); err != nil {
+		return err
+	}
+	_, err := file.WriteAt(make([]byte, 2*blockSize), end)
+	return err
+}
@@ -0,0 +1,29 @@
+package tar
+
+import (
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// ManifestName is the entry WithManifest adds at the end of an archive.
+const ManifestName = archiver.ManifestName
+
+// Manifest lists the SHA-256, size and mode of every regular file in an
+// archive written with WithManifest.
+type Manifest = archiver.Manifest
+
+// ManifestFile is one file of a Manifest.
+type ManifestFile = archiver.ManifestFile
+
+var (
+	// ErrNoManifest is returned for archives written without WithManifest.
+	ErrNoManifest = archiver.ErrNoManifest
+	// ErrManifestMismatch is wrapped by the errors of files that differ
+	// from the manifest.
+	ErrManifestMismatch = archiver.ErrManifestMismatch
+)
+
+// ReadManifest returns the manifest of tarName, or ErrNoManifest. Its Audit
+// method checks a tree extracted from the archive against it.
+func ReadManifest(tarName string) (*Manifest, error) {
+	return archiver.LoadManifest(ReadFile(tarName, ManifestName))
+}
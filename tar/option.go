@@ -0,0 +1,170 @@
+package tar
+
+import (
+	"time"
+
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// Option configures Compress and Extract.
+type Option = archiver.Option
+
+// Progress is what WithProgress reports; sizes that are not known are -1.
+type Progress = archiver.Progress
+
+// WithProgress calls fn when an entry starts and after every chunk of its
+// content, on the goroutine doing the work.
+func WithProgress(fn func(Progress)) Option {
+	return archiver.WithProgress(fn)
+}
+
+// WithInclude makes Extract write only entries matching one of patterns.
+// Patterns are path.Match patterns over slash-separated archive paths, where
+// "**" matches any number of directories, e.g. "*/bin" or "**/*.so".
+// A pattern matching a directory selects everything below it, and a link
+// that does not match is still restored when its target is selected.
+func WithInclude(patterns ...string) Option {
+	return archiver.WithInclude(patterns...)
+}
+
+// WithExclude makes Extract skip entries matching one of patterns;
+// it wins over WithInclude.
+func WithExclude(patterns ...string) Option {
+	return archiver.WithExclude(patterns...)
+}
+
+// WithStripComponents makes Extract drop the first n elements of every
+// entry path, like tar --strip-components; entries with no more than n
+// elements are skipped.
+func WithStripComponents(n int) Option {
+	return archiver.WithStripComponents(n)
+}
+
+// WithRename makes Extract write each entry to fn(name) instead, where name
+// is the archive path after WithStripComponents; an empty result skips the
+// entry. Link targets are rewritten to follow the renamed paths, while
+// WithInclude and WithExclude still match the original archive paths.
+func WithRename(fn func(name string) string) Option {
+	return archiver.WithRename(fn)
+}
+
+// WithReproducible makes Compress write the same bytes for the same tree on
+// any host: entries in sorted order, no owner or group, permissions 0755 or
+// 0644, times in whole seconds and no later than the SOURCE_DATE_EPOCH
+// environment variable if it is set.
+func WithReproducible() Option {
+	return archiver.WithReproducible()
+}
+
+// WithSourceDateEpoch is WithReproducible with times clamped to t instead
+// of SOURCE_DATE_EPOCH.
+func WithSourceDateEpoch(t time.Time) Option {
+	return archiver.WithSourceDateEpoch(t)
+}
+
+// WithPreserveOwner makes Extract restore the owner and group of entries,
+// by name where the name exists on this system and by id otherwise. It only
+// has an effect when running as root, and also restores setuid, setgid and
+// sticky bits, which are otherwise left out like the umask leaves them out.
+func WithPreserveOwner() Option {
+	return archiver.WithPreserveOwner()
+}
+
+// WithXattrs makes Extract restore extended attributes recorded in PAX
+// records, as written by GNU tar --xattrs and bsdtar. Attributes the file
+// system or the user may not set are skipped; ACL records are not restored.
+func WithXattrs() Option {
+	return archiver.WithXattrs()
+}
+
+// WithCopyLinks makes Extract fall back to copying the content of a hard
+// link's target where the file system can't create hard links, such as
+// FAT or some network shares.
+func WithCopyLinks() Option {
+	return archiver.WithCopyLinks()
+}
+
+// WithManifest makes Compress add a manifest with the SHA-256, size and mode
+// of every regular file as the last entry, named ManifestName. It is read
+// like any other file, and survives converting the archive to another format.
+// Append fails with ErrAppendManifest.
+func WithManifest() Option {
+	return archiver.WithManifest()
+}
+
+// WithCheckManifest makes Extract hash every file it writes and fail with
+// ErrManifestMismatch if any differs from the archive's manifest, or with
+// ErrNoManifest if there is none. Files already written are left in place.
+func WithCheckManifest() Option {
+	return archiver.WithCheckManifest()
+}
+
+// WithAtomic makes Extract write to a hidden directory next to dest and
+// rename it to dest only once every entry is in place, so dest never holds
+// a partial extraction: on any error, cancellation or panic the staging
+// directory is removed and dest is left as it was. dest must not exist or
+// be an empty directory, else Extract fails with ErrDestinationNotEmpty.
+func WithAtomic() Option {
+	return archiver.WithAtomic()
+}
+
+// Policy decides what Extract does when an entry's path is already taken
+// at the destination by something Extract did not write itself.
+type Policy = archiver.Policy
+
+// Policies for WithPolicy.
+const (
+	// Overwrite replaces what is in the way, the default.
+	Overwrite = archiver.Overwrite
+	// SkipExisting leaves what is there and skips the entry.
+	SkipExisting = archiver.SkipExisting
+	// KeepNewer skips the entry if what is there was modified after it.
+	KeepNewer = archiver.KeepNewer
+	// FailOnConflict stops Extract with ErrConflict.
+	FailOnConflict = archiver.FailOnConflict
+	// Backup renames what is in the way, see WithBackup.
+	Backup = archiver.Backup
+)
+
+// ErrConflict is returned by Extract with FailOnConflict.
+var ErrConflict = archiver.ErrConflict
+
+// ErrLinkTargetExcluded is returned by Extract for a hard link it would
+// write whose target WithInclude, WithExclude or WithRename left out.
+var ErrLinkTargetExcluded = archiver.ErrLinkTargetExcluded
+
+// Changes lists the paths Extract created, replaced and skipped, slash
+// separated and relative to dest; directories only when created.
+type Changes = archiver.Changes
+
+// WithPolicy sets what Extract does with files, symlinks and hard links
+// whose path is already taken; existing directories are always merged into.
+// Whatever is replaced is removed first rather than written over, so
+// nothing is written through a symlink or into another hard link of a file.
+func WithPolicy(p Policy) Option {
+	return archiver.WithPolicy(p)
+}
+
+// WithBackup is WithPolicy(Backup), appending suffix to the names of what
+// is in the way, "~" if suffix is empty. An older backup is replaced.
+func WithBackup(suffix string) Option {
+	return archiver.WithBackup(suffix)
+}
+
+// WithChanges makes Extract record in c what it created, replaced and
+// skipped.
+func WithChanges(c *Changes) Option {
+	return archiver.WithChanges(c)
+}
+
+// WithSync makes Extract bring dest in line with the archive, for upgrading
+// a tool in place: files that are the same are left alone, and whatever the
+// archive does not have, such as files deleted upstream, is removed once
+// every entry is in place. Like rsync, a regular file with the size and
+// modification time of its entry is taken to be unchanged without being
+// read, and only its mode is corrected. With WithInclude or WithExclude, dest
+// is made to match the selected entries. What was removed or had its mode
+// corrected is listed by WithChanges under Removed and Updated.
+func WithSync() Option {
+	return archiver.WithSync()
+}
@@ -0,0 +1,245 @@
+// Package tar works with plain, uncompressed tar archives, and can append
+// to them.
+package tar
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// ErrInsecurePath is returned by Extract for an entry, symlink or hard link
+// that would land outside dest.
+var ErrInsecurePath = archiver.ErrInsecurePath
+
+// ErrDestinationNotEmpty is returned by Extract with WithAtomic when the
+// destination exists and is not an empty directory.
+var ErrDestinationNotEmpty = archiver.ErrDestinationNotEmpty
+
+// Compress creates the tar archive tarName from files; directories are added
+// with everything below them.
+func Compress(tarName string, files ...string) error {
+	return CompressWithOptions(context.Background(), tarName, files)
+}
+
+// CompressContext is Compress that stops once ctx is done,
+// removing the partially written archive.
+func CompressContext(ctx context.Context, tarName string, files ...string) error {
+	return CompressWithOptions(ctx, tarName, files)
+}
+
+// CompressWithOptions is CompressContext configured by opts.
+func CompressWithOptions(ctx context.Context, tarName string, files []string, opts ...Option) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			if err := os.Remove(tarName); err != nil {
+				log.Errorf("Error removing archive: %v", err)
+			}
+		}
+	}()
+	created, err := os.Create(tarName)
+	if err != nil {
+		log.Errorf("Error creating archive: %v", err)
+		return err
+	}
+	defer func(created *os.File) {
+		err := created.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
+		}
+	}(created)
+	return CompressToWithOptions(ctx, created, files, opts...)
+}
+
+// CompressTo writes a tar of files to w, e.g. an HTTP response or stdout.
+// w is not closed.
+func CompressTo(w io.Writer, files ...string) error {
+	return CompressToWithOptions(context.Background(), w, files)
+}
+
+// CompressToContext is CompressTo that stops once ctx is done.
+func CompressToContext(ctx context.Context, w io.Writer, files ...string) error {
+	return CompressToWithOptions(ctx, w, files)
+}
+
+// CompressToWithOptions is CompressToContext configured by opts.
+func CompressToWithOptions(ctx context.Context, w io.Writer, files []string, opts ...Option) error {
+	cfg := archiver.NewConfig(opts...)
+	total := int64(-1)
+	if cfg.Progress != nil {
+		total = archiver.TotalSize(files)
+	}
+	meter := cfg.Meter(total)
+
+	tarWriter := tar.NewWriter(w)
+	if err := archiver.WriteTar(ctx, tarWriter, files, cfg, meter); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		log.Errorf("Error closing tar: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Extract extracts the tar name into dest.
+func Extract(name, dest string, opts ...Option) error {
+	return ExtractContext(context.Background(), name, dest, opts...)
+}
+
+// ExtractContext is Extract that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractContext(ctx context.Context, name, dest string, opts ...Option) error {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	return ExtractFromContext(ctx, file, dest, opts...)
+}
+
+// ExtractFrom extracts a tar read from r, e.g. an HTTP body or stdin, into dest.
+func ExtractFrom(r io.Reader, dest string, opts ...Option) error {
+	return ExtractFromContext(context.Background(), r, dest, opts...)
+}
+
+// ExtractFromContext is ExtractFrom that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractFromContext(ctx context.Context, r io.Reader, dest string, opts ...Option) error {
+	x, err := archiver.NewExtractor(ctx, dest, archiver.NewConfig(opts...), -1)
+	if err != nil {
+		return err
+	}
+	defer func(x *archiver.Extractor) {
+		err := x.Close()
+		if err != nil {
+			log.Errorf("Error closing destination: %v", err)
+		}
+	}(x)
+
+	return x.Extract(archiver.TarSource(tar.NewReader(x.Reader(r))))
+}
+
+// FileIn reports whether tarName has an entry whose name ends in filename.
+func FileIn(filename, tarName string) bool {
+	entries, err := ListEntries(tarName)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name, filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// List lists the entries of tarName, directories with a trailing slash.
+func List(tarName string) ([]string, error) {
+	return ListContext(context.Background(), tarName)
+}
+
+// ListContext is List that stops once ctx is done.
+func ListContext(ctx context.Context, tarName string) ([]string, error) {
+	entries, err := ListEntriesContext(ctx, tarName)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.Strings(entries), nil
+}
+
+// Entry describes one member of an archive.
+type Entry = archiver.Entry
+
+// ListEntries describes every member of tarName, including hard links and
+// special files.
+func ListEntries(tarName string) ([]Entry, error) {
+	return ListEntriesContext(context.Background(), tarName)
+}
+
+// ListEntriesContext is ListEntries that stops once ctx is done.
+func ListEntriesContext(ctx context.Context, tarName string) ([]Entry, error) {
+	src, closer, err := openSource(tarName)()
+	if err != nil {
+		return nil, err
+	}
+	defer func(closer io.Closer) {
+		err := closer.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(closer)
+	return archiver.ListEntries(ctx, src)
+}
+
+// FS is a read-only fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS view
+// of an archive. Close it when done.
+type FS = archiver.FS
+
+// OpenFS indexes tarName for use as an fs.FS, reading entries straight
+// from the file.
+func OpenFS(tarName string) (*FS, error) {
+	file, err := os.Open(tarName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		log.Errorf("Error stating file: %v", err)
+		_ = file.Close()
+		return nil, err
+	}
+	return archiver.NewTarFSAt(context.Background(), file, info.Size(), file)
+}
+
+// Open returns the content of the regular file entry in tarName, read
+// straight from the archive without extracting anything else. Symlinks and
+// hard links inside the archive are followed.
+func Open(tarName, entry string) (io.ReadCloser, error) {
+	fsys, err := OpenFS(tarName)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.OpenFSEntry(fsys, entry)
+}
+
+// ReadFile returns the content of the regular file entry in tarName, like Open.
+func ReadFile(tarName, entry string) ([]byte, error) {
+	rc, err := Open(tarName, entry)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rc io.ReadCloser) {
+		err := rc.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
+		}
+	}(rc)
+	return io.ReadAll(rc)
+}
+
+func openSource(tarName string) archiver.OpenFunc {
+	return func() (archiver.Source, io.Closer, error) {
+		file, err := os.Open(tarName)
+		if err != nil {
+			log.Errorf("Error opening file: %v", err)
+			return nil, nil, err
+		}
+		return archiver.TarSource(tar.NewReader(file)), file, nil
+	}
+}
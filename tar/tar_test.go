@@ -0,0 +1,152 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	for _, layer := range []string{"base", "app", "config"} {
+		if err := os.MkdirAll(filepath.Join(dir, layer), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, layer, layer+".txt"), []byte(layer), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(dir, "layers.tar")
+	if err := Append(archive, filepath.Join(dir, "base")); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := Append(archive, filepath.Join(dir, "app")); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	expected := []string{"base/", "base/base.txt", "app/", "app/app.txt"}
+	entries, err := ListEntries(archive)
+	var actual []string
+	for _, entry := range entries {
+		actual = append(actual, entry.Name)
+	}
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", expected, actual, err)
+	}
+	if report, err := Verify(archive); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected a sound archive, got: %+v (%v)", report, err)
+	}
+	data, err := ReadFile(archive, "app/app.txt")
+	if err != nil || string(data) != "app" {
+		t.Errorf("Test failed, expected: 'app', got:  '%s' (%v)", data, err)
+	}
+
+	before, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := AppendContext(ctx, archive, filepath.Join(dir, "config")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", context.Canceled, err)
+	}
+	err = AppendWithOptions(context.Background(), archive, []string{filepath.Join(dir, "config")}, WithManifest())
+	if !errors.Is(err, ErrAppendManifest) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", ErrAppendManifest, err)
+	}
+	after, err := os.ReadFile(archive)
+	if err != nil || !bytes.Equal(before, after) {
+		t.Errorf("Test failed, expected the archive to be left as it was, got %d bytes instead of %d (%v)", len(after), len(before), err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := Extract(archive, out); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "base", "base.txt")); err != nil || string(data) != "base" {
+		t.Errorf("Test failed, expected: 'base', got:  '%s' (%v)", data, err)
+	}
+}
+
+func TestAppendToGnuTar(t *testing.T) {
+	gnuTar, err := exec.LookPath("tar")
+	if err != nil {
+		t.Skip("tar not found")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "gnu.tar")
+	// tar pads the archive to whole records, well past the marker
+	if out, err := exec.Command(gnuTar, "-cf", archive, "-C", dir, "a.txt").CombinedOutput(); err != nil {
+		t.Fatalf("error: %s %s", err, out)
+	}
+	if err := Append(archive, filepath.Join(dir, "b")); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	out, err := exec.Command(gnuTar, "-tf", archive).CombinedOutput()
+	if err != nil {
+		t.Fatalf("error: %s %s", err, out)
+	}
+	if lines := strings.Fields(string(out)); !reflect.DeepEqual(lines, []string{"a.txt", "b/"}) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", []string{"a.txt", "b/"}, lines)
+	}
+	if report, err := Verify(archive); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected a sound archive, got: %+v (%v)", report, err)
+	}
+}
+
+func TestAppendWithoutMarker(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "cut.tar")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last entry ends in zero blocks, and no marker follows
+	tarWriter := tar.NewWriter(file)
+	for name, data := range map[string][]byte{"a.txt": []byte("a"), "zeros.bin": make([]byte, 1024)} {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Append(archive, filepath.Join(dir, "b")); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	entries, err := ListEntries(archive)
+	var actual []string
+	for _, entry := range entries {
+		actual = append(actual, entry.Name)
+	}
+	if err != nil || len(actual) != 3 || actual[2] != "b/" {
+		t.Errorf("Test failed, expected three entries ending with 'b/', got:  '%v' (%v)", actual, err)
+	}
+	data, err := ReadFile(archive, "zeros.bin")
+	if err != nil || !bytes.Equal(data, make([]byte, 1024)) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", 1024, len(data), err)
+	}
+}
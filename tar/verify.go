@@ -0,0 +1,50 @@
+package tar
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// Report is what Verify found; Problems is empty for a sound archive.
+type Report = archiver.Report
+
+// Problem is one thing Verify found wrong, with the entry it was found in.
+type Problem = archiver.Problem
+
+// Verify reads all of tarName without writing anything, checking the tar
+// headers and every entry's content, so truncated or corrupt downloads show
+// before Extract. The error is only for an archive that can't be opened;
+// what is wrong inside is in the report.
+func Verify(tarName string, opts ...Option) (*Report, error) {
+	return VerifyContext(context.Background(), tarName, opts...)
+}
+
+// VerifyContext is Verify that stops once ctx is done.
+func VerifyContext(ctx context.Context, tarName string, opts ...Option) (*Report, error) {
+	file, err := os.Open(tarName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	meter := archiver.NewConfig(opts...).Meter(-1)
+
+	report := &Report{}
+	src := archiver.TarSource(tar.NewReader(archiver.Reader(ctx, meter.Reader(file))))
+	if err := archiver.VerifySource(ctx, src, meter, report); err != nil {
+		return report, err
+	}
+	return report, ctx.Err()
+}
@@ -0,0 +1,166 @@
+// Package tbz2 reads tar.bz2 archives. It only extracts and lists them:
+// the standard library has no bzip2 compressor.
+package tbz2
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// ErrInsecurePath is returned by Extract for an entry, symlink or hard link
+// that would land outside dest.
+var ErrInsecurePath = archiver.ErrInsecurePath
+
+// ErrDestinationNotEmpty is returned by Extract with WithAtomic when the
+// destination exists and is not an empty directory.
+var ErrDestinationNotEmpty = archiver.ErrDestinationNotEmpty
+
+// Extract extracts the tar.bz2 name into dest.
+func Extract(name, dest string, opts ...Option) error {
+	return ExtractContext(context.Background(), name, dest, opts...)
+}
+
+// ExtractContext is Extract that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractContext(ctx context.Context, name, dest string, opts ...Option) error {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	return ExtractFromContext(ctx, file, dest, opts...)
+}
+
+// ExtractFrom extracts a tar.bz2 read from r, e.g. an HTTP body or stdin, into dest.
+func ExtractFrom(r io.Reader, dest string, opts ...Option) error {
+	return ExtractFromContext(context.Background(), r, dest, opts...)
+}
+
+// ExtractFromContext is ExtractFrom that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractFromContext(ctx context.Context, r io.Reader, dest string, opts ...Option) error {
+	x, err := archiver.NewExtractor(ctx, dest, archiver.NewConfig(opts...), -1)
+	if err != nil {
+		return err
+	}
+	defer func(x *archiver.Extractor) {
+		err := x.Close()
+		if err != nil {
+			log.Errorf("Error closing destination: %v", err)
+		}
+	}(x)
+
+	return x.Extract(archiver.TarSource(tar.NewReader(bzip2.NewReader(x.Reader(r)))))
+}
+
+// FileIn reports whether tbz2Name has an entry whose name ends in filename.
+func FileIn(filename, tbz2Name string) bool {
+	entries, err := ListEntries(tbz2Name)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name, filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// List lists the entries of tbz2Name, directories with a trailing slash.
+func List(tbz2Name string) ([]string, error) {
+	return ListContext(context.Background(), tbz2Name)
+}
+
+// ListContext is List that stops once ctx is done.
+func ListContext(ctx context.Context, tbz2Name string) ([]string, error) {
+	entries, err := ListEntriesContext(ctx, tbz2Name)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.Strings(entries), nil
+}
+
+// Entry describes one member of an archive.
+type Entry = archiver.Entry
+
+// ListEntries describes every member of tbz2Name, including hard links and
+// special files.
+func ListEntries(tbz2Name string) ([]Entry, error) {
+	return ListEntriesContext(context.Background(), tbz2Name)
+}
+
+// ListEntriesContext is ListEntries that stops once ctx is done.
+func ListEntriesContext(ctx context.Context, tbz2Name string) ([]Entry, error) {
+	src, closer, err := openSource(tbz2Name)()
+	if err != nil {
+		return nil, err
+	}
+	defer func(closer io.Closer) {
+		err := closer.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(closer)
+	return archiver.ListEntries(ctx, src)
+}
+
+// FS is a read-only fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS view
+// of an archive. Close it when done.
+type FS = archiver.FS
+
+// OpenFS indexes tbz2Name for use as an fs.FS. The archive is decompressed
+// once, into a temporary file that FS.Close removes.
+func OpenFS(tbz2Name string) (*FS, error) {
+	file, err := os.Open(tbz2Name)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	return archiver.NewTarFS(context.Background(), bzip2.NewReader(file))
+}
+
+// Open returns the content of the regular file entry in tbz2Name, read
+// straight from the archive without extracting anything else. Symlinks and
+// hard links inside the archive are followed.
+func Open(tbz2Name, entry string) (io.ReadCloser, error) {
+	return archiver.OpenEntry(context.Background(), openSource(tbz2Name), entry)
+}
+
+// ReadFile returns the content of the regular file entry in tbz2Name, like Open.
+func ReadFile(tbz2Name, entry string) ([]byte, error) {
+	return archiver.ReadEntry(context.Background(), openSource(tbz2Name), entry)
+}
+
+func openSource(tbz2Name string) archiver.OpenFunc {
+	return func() (archiver.Source, io.Closer, error) {
+		file, err := os.Open(tbz2Name)
+		if err != nil {
+			log.Errorf("Error opening file: %v", err)
+			return nil, nil, err
+		}
+		return archiver.TarSource(tar.NewReader(bzip2.NewReader(file))), file, nil
+	}
+}
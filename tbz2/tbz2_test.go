@@ -0,0 +1,82 @@
+package tbz2
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testdata/src.tar.bz2 was made with bzip2 -9 from a reproducible tar of
+// src/hello.txt, src/bin/tool and the symlink src/tool, with a manifest.
+const fixture = "testdata/src.tar.bz2"
+
+func TestList(t *testing.T) {
+	expected := []string{"src/", "src/bin/", "src/bin/tool", "src/hello.txt", "src/tool", ".manifest.sha256.json"}
+	entries, err := ListEntries(fixture)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if err != nil || !reflect.DeepEqual(names, expected) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", expected, names, err)
+	}
+	if !FileIn("src/hello.txt", fixture) || FileIn("src/missing.txt", fixture) {
+		t.Errorf("Test failed, expected FileIn to find only src/hello.txt")
+	}
+	data, err := ReadFile(fixture, "src/hello.txt")
+	if err != nil || string(data) != "hello\n" {
+		t.Errorf("Test failed, expected: 'hello', got:  '%s' (%v)", data, err)
+	}
+
+	fsys, err := OpenFS(fixture)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	defer fsys.Close()
+	if data, err := fs.ReadFile(fsys, "src/bin/tool"); err != nil || string(data) != "#!/bin/sh\necho hi\n" {
+		t.Errorf("Test failed, expected: '%v', got:  '%s' (%v)", "#!/bin/sh\necho hi\n", data, err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	if report, err := Verify(fixture); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected a sound archive, got: %+v (%v)", report, err)
+	}
+	manifest, err := ReadManifest(fixture)
+	if err != nil || len(manifest.Files) != 2 {
+		t.Errorf("Test failed, expected a manifest of 2 files, got:  '%+v' (%v)", manifest, err)
+	}
+
+	dest := t.TempDir()
+	if err := Extract(fixture, dest, WithCheckManifest()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "src", "bin", "tool"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", "-rwxr-xr-x", info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "src", "tool")); link != "bin/tool" {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", "bin/tool", link, err)
+	}
+	if report, err := manifest.Audit(dest); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected an intact tree, got: %+v (%v)", report, err)
+	}
+
+	file, err := os.Open(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dest = t.TempDir()
+	if err := ExtractFrom(file, dest, WithInclude("src/hello.txt")); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "src", "hello.txt")); err != nil || string(data) != "hello\n" {
+		t.Errorf("Test failed, expected: 'hello', got:  '%s' (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "src", "bin")); !os.IsNotExist(err) {
+		t.Errorf("Test failed, expected only src/hello.txt, got src/bin (%v)", err)
+	}
+}
@@ -0,0 +1,58 @@
+package tbz2
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"context"
+	"io"
+	"os"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// Report is what Verify found; Problems is empty for a sound archive.
+type Report = archiver.Report
+
+// Problem is one thing Verify found wrong, with the entry it was found in.
+type Problem = archiver.Problem
+
+// Verify reads all of tbz2Name without writing anything, checking the tar
+// headers, every entry's content and the bzip2 block checksums, so
+// truncated or corrupt downloads show before Extract. The error is only for
+// an archive that can't be opened; what is wrong inside is in the report.
+func Verify(tbz2Name string, opts ...Option) (*Report, error) {
+	return VerifyContext(context.Background(), tbz2Name, opts...)
+}
+
+// VerifyContext is Verify that stops once ctx is done.
+func VerifyContext(ctx context.Context, tbz2Name string, opts ...Option) (*Report, error) {
+	file, err := os.Open(tbz2Name)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	meter := archiver.NewConfig(opts...).Meter(-1)
+
+	report := &Report{}
+	bz2Reader := bzip2.NewReader(archiver.Reader(ctx, meter.Reader(file)))
+	if err := archiver.VerifySource(ctx, archiver.TarSource(tar.NewReader(bz2Reader)), meter, report); err != nil {
+		return report, err
+	}
+	if report.OK() {
+		// The stream checksum at the end is only checked when it is read
+		if _, err := io.Copy(io.Discard, bz2Reader); err != nil {
+			report.Add("", err)
+		}
+	}
+	return report, ctx.Err()
+}
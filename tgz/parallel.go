@@ -0,0 +1,182 @@
+package tgz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// blockSize is how much input each goroutine of a parallelWriter deflates.
+const blockSize = 1 << 20
+
+// dictSize is the deflate window, the tail of a block the next one is primed with.
+const dictSize = 32 << 10
+
+// parallelWriter is a gzip writer that deflates blocks on several goroutines,
+// the way pigz does. Every block is compressed on its own, primed with the
+// tail of the previous one and ended by a sync flush, so the compressed
+// blocks join into a single deflate stream any gzip reader can read.
+type parallelWriter struct {
+	w       io.Writer
+	level   int
+	writers sync.Pool
+
+	block   []byte
+	dict    []byte
+	crc     uint32
+	size    uint32
+	started bool
+	closed  bool
+
+	pending chan chan []byte
+	done    chan struct{}
+	mu      sync.Mutex
+	err     error
+}
+
+func newParallelWriter(w io.Writer, level, concurrency int) (*parallelWriter, error) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	return &parallelWriter{
+		w:       w,
+		level:   level,
+		block:   make([]byte, 0, blockSize),
+		pending: make(chan chan []byte, concurrency),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (z *parallelWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("gzip: write after close")
+	}
+	if err := z.error(); err != nil {
+		return 0, err
+	}
+	if !z.started {
+		z.start()
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := copy(z.block[len(z.block):cap(z.block)], p)
+		z.block = z.block[:len(z.block)+m]
+		p = p[m:]
+		if len(z.block) == cap(z.block) {
+			z.dispatch(false)
+		}
+	}
+	return n, nil
+}
+
+// Close compresses what is left, waits for all blocks to be written and
+// ends the stream with the gzip trailer. It does not close the underlying writer.
+func (z *parallelWriter) Close() error {
+	if z.closed {
+		return z.error()
+	}
+	z.closed = true
+	if !z.started {
+		z.start()
+	}
+	z.dispatch(true)
+	close(z.pending)
+	<-z.done
+	if err := z.error(); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	_, err := z.w.Write(trailer[:])
+	return err
+}
+
+// start writes the gzip header and the goroutine that writes compressed
+// blocks out in order.
+func (z *parallelWriter) start() {
+	z.started = true
+	header := [10]byte{0: 0x1f, 1: 0x8b, 2: 8, 9: 255}
+	switch z.level {
+	case flate.BestCompression:
+		header[8] = 2
+	case flate.BestSpeed:
+		header[8] = 4
+	}
+	if _, err := z.w.Write(header[:]); err != nil {
+		z.setError(err)
+	}
+	go func() {
+		defer close(z.done)
+		for result := range z.pending {
+			compressed := <-result
+			if z.error() != nil {
+				continue
+			}
+			if _, err := z.w.Write(compressed); err != nil {
+				z.setError(err)
+			}
+		}
+	}()
+}
+
+// dispatch hands the current block to a new goroutine; it blocks while
+// as many blocks as the concurrency are still in flight.
+func (z *parallelWriter) dispatch(last bool) {
+	block, dict := z.block, z.dict
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, block)
+	z.size += uint32(len(block))
+
+	result := make(chan []byte, 1)
+	z.pending <- result
+	go func() {
+		var buf bytes.Buffer
+		buf.Grow(len(block) / 2)
+		fw, _ := z.writers.Get().(*flate.Writer)
+		if fw == nil {
+			// The level was checked in newParallelWriter
+			fw, _ = flate.NewWriterDict(&buf, z.level, dict)
+		} else {
+			fw.ResetDict(&buf, dict)
+		}
+		_, err := fw.Write(block)
+		if err == nil && last {
+			err = fw.Close()
+		} else if err == nil {
+			err = fw.Flush()
+		}
+		z.writers.Put(fw)
+		if err != nil {
+			z.setError(err)
+		}
+		result <- buf.Bytes()
+	}()
+
+	if last {
+		return
+	}
+	// Only full blocks get here. The next one gets a fresh buffer, as this
+	// one is still being read
+	z.dict = block[len(block)-dictSize:]
+	z.block = make([]byte, 0, blockSize)
+}
+
+func (z *parallelWriter) error() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+func (z *parallelWriter) setError(err error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+}
@@ -0,0 +1,49 @@
+package tgz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestParallelWriter(t *testing.T) {
+	// Compressible but not trivially so, and spanning several blocks
+	words := []string{"alpha ", "beta ", "gamma ", "delta\n"}
+	rnd := rand.New(rand.NewSource(1))
+	var data bytes.Buffer
+	for data.Len() < 3*blockSize+12345 {
+		data.WriteString(words[rnd.Intn(len(words))])
+	}
+
+	for _, size := range []int{0, 100, data.Len()} {
+		var compressed bytes.Buffer
+		z, err := newParallelWriter(&compressed, BestSpeed, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := z.Write(data.Bytes()[:size]); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := gzip.NewReader(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if !bytes.Equal(got, data.Bytes()[:size]) {
+			t.Errorf("Test failed, expected: '%v' bytes, got:  '%v' bytes", size, len(got))
+		}
+	}
+
+	if _, err := newParallelWriter(io.Discard, 42, 4); err == nil {
+		t.Errorf("Test failed, expected an error for a bad level")
+	}
+}
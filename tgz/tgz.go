@@ -2,18 +2,45 @@ package tgz
 
 import (
 	"archive/tar"
-	"compress/gzip"
-	"fmt"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/klauspost/compress/gzip"
 	"github.com/labstack/gommon/log"
-	"github.com/qiuzhanghua/common/util"
+	"github.com/qiuzhanghua/common/internal/archiver"
 )
 
+// ErrInsecurePath is returned by Extract for an entry, symlink or hard link
+// that would land outside dest.
+var ErrInsecurePath = archiver.ErrInsecurePath
+
+// ErrDestinationNotEmpty is returned by Extract with WithAtomic when the
+// destination exists and is not an empty directory.
+var ErrDestinationNotEmpty = archiver.ErrDestinationNotEmpty
+
 func Compress(tgzName string, files ...string) error {
+	return CompressWithOptions(context.Background(), tgzName, files)
+}
+
+// CompressContext is Compress that stops once ctx is done,
+// removing the partially written archive.
+func CompressContext(ctx context.Context, tgzName string, files ...string) error {
+	return CompressWithOptions(ctx, tgzName, files)
+}
+
+// CompressWithOptions is CompressContext configured by opts.
+func CompressWithOptions(ctx context.Context, tgzName string, files []string, opts ...Option) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			if err := os.Remove(tgzName); err != nil {
+				log.Errorf("Error removing archive: %v", err)
+			}
+		}
+	}()
 	created, err := os.Create(tgzName)
 	if err != nil {
 		log.Errorf("Error creating archive: %v", err)
@@ -25,118 +52,59 @@ func Compress(tgzName string, files ...string) error {
 			log.Errorf("Error closing archive: %v", err)
 		}
 	}(created)
-	gzipWriter := gzip.NewWriter(created)
-	defer func(gzipWriter *gzip.Writer) {
-		err := gzipWriter.Close()
-		if err != nil {
-			log.Errorf("Error closing gzip: %v", err)
-		}
-	}(gzipWriter)
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer func(tarWriter *tar.Writer) {
-		err := tarWriter.Close()
-		if err != nil {
-			log.Errorf("Error closing tar: %v", err)
-		}
-	}(tarWriter)
+	return CompressToWithOptions(ctx, created, files, opts...)
+}
 
-	for _, src := range files {
-		info, err := os.Stat(src)
-		if err != nil {
-			log.Errorf("Error stating files: %v", err)
-			return err
-		}
-		var baseDir string
-		if info.IsDir() {
-			baseDir = filepath.Base(src)
-		}
-		//fmt.Println("baseDir:", baseDir)
-		err = filepath.Walk(src,
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					log.Errorf("Error walking path: %v", err)
-					return err
-				}
-				header := new(tar.Header)
-				header.Name = path
-				header.Size = info.Size()
-				header.Mode = int64(info.Mode())
-				header.ModTime = info.ModTime()
-				header.AccessTime = info.ModTime()
-				header.ChangeTime = info.ModTime()
-
-				//fmt.Println("path:", path)
-				//fmt.Println("info:", info.Name(), info.Mode(), info.Mode().Type()&os.ModeSymlink != 0, info.Sys())
-
-				if baseDir != "" {
-					header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, src))
-				}
-
-				if info.IsDir() {
-					header.Name += "/"
-					header.Typeflag = tar.TypeDir
-				} else if info.Mode().Type()&os.ModeSymlink != 0 {
-					link, err := os.Readlink(path)
-					if err != nil {
-						log.Errorf("Error reading symlink: %v", err)
-						return err
-					}
-					header.Linkname = link
-					header.Typeflag = tar.TypeSymlink
-				} else if info.Mode().IsRegular() {
-					header.Typeflag = tar.TypeReg
-				} else {
-					log.Errorf("Error unsupported type: %c for %s", info.Mode().Type(), path)
-					return fmt.Errorf("unsupported type: %c in %s", info.Mode().Type(), path)
-				}
-				//fmt.Println("baseDir:", baseDir)
-				if err := tarWriter.WriteHeader(header); err != nil {
-					log.Errorf("Error writing header: %v", err)
-					return err
-				}
-
-				if info.IsDir() || info.Mode().Type()&os.ModeSymlink != 0 {
-					return nil
-				}
-
-				file, err := os.Open(path)
-				if err != nil {
-					log.Errorf("Error opening file: %v", err)
-					return err
-				}
-				defer func(file *os.File) {
-					err := file.Close()
-					if err != nil {
-						log.Errorf("Error closing file: %v", err)
-					}
-				}(file)
-
-				_, err = io.Copy(tarWriter, file)
-				if err != nil {
-					log.Errorf("Error copying file data: %v %s", err, path)
-					return err
-				}
-				return nil
-			})
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// CompressTo writes a tar.gz of files to w, e.g. an HTTP response or stdout.
+// w is not closed.
+func CompressTo(w io.Writer, files ...string) error {
+	return CompressToWithOptions(context.Background(), w, files)
+}
+
+// CompressToContext is CompressTo that stops once ctx is done.
+func CompressToContext(ctx context.Context, w io.Writer, files ...string) error {
+	return CompressToWithOptions(ctx, w, files)
 }
 
-func Extract(name, dest string) error {
-	dest, err := util.ExpandHome(dest)
+// CompressToWithOptions is CompressToContext configured by opts.
+func CompressToWithOptions(ctx context.Context, w io.Writer, files []string, opts ...Option) error {
+	cfg := archiver.NewConfig(opts...)
+	total := int64(-1)
+	if cfg.Progress != nil {
+		total = archiver.TotalSize(files)
+	}
+	meter := cfg.Meter(total)
+
+	gzipWriter, err := newGzipWriter(w, cfg)
 	if err != nil {
-		log.Errorf("Error expanding home dir: %v", err)
+		log.Errorf("Error creating gzip: %v", err)
 		return err
 	}
-	dest, err = util.AbsPath(dest)
-	if err != nil {
-		log.Errorf("Error getting absolute path: %v", err)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	if err := archiver.WriteTar(ctx, tarWriter, files, cfg, meter); err != nil {
+		_ = gzipWriter.Close()
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		log.Errorf("Error closing tar: %v", err)
+		_ = gzipWriter.Close()
 		return err
 	}
+	if err := gzipWriter.Close(); err != nil {
+		log.Errorf("Error closing gzip: %v", err)
+		return err
+	}
+	return nil
+}
+
+func Extract(name, dest string, opts ...Option) error {
+	return ExtractContext(context.Background(), name, dest, opts...)
+}
 
+// ExtractContext is Extract that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractContext(ctx context.Context, name, dest string, opts ...Option) error {
 	file, err := os.Open(name)
 	if err != nil {
 		log.Errorf("Error opening file: %v", err)
@@ -148,7 +116,32 @@ func Extract(name, dest string) error {
 			log.Errorf("Error closing file: %v", err)
 		}
 	}(file)
-	gzipReader, err := gzip.NewReader(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	return ExtractFromContext(ctx, file, dest, opts...)
+}
+
+// ExtractFrom extracts a tar.gz read from r, e.g. an HTTP body or stdin, into dest.
+func ExtractFrom(r io.Reader, dest string, opts ...Option) error {
+	return ExtractFromContext(context.Background(), r, dest, opts...)
+}
+
+// ExtractFromContext is ExtractFrom that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractFromContext(ctx context.Context, r io.Reader, dest string, opts ...Option) error {
+	x, err := archiver.NewExtractor(ctx, dest, archiver.NewConfig(opts...), -1)
+	if err != nil {
+		return err
+	}
+	defer func(x *archiver.Extractor) {
+		err := x.Close()
+		if err != nil {
+			log.Errorf("Error closing destination: %v", err)
+		}
+	}(x)
+
+	gzipReader, err := gzip.NewReader(x.Reader(r))
 	if err != nil {
 		log.Errorf("Error reading gzip: %v", err)
 		return err
@@ -159,80 +152,7 @@ func Extract(name, dest string) error {
 			log.Errorf("Error closing gzip: %v", err)
 		}
 	}(gzipReader)
-	tarReader := tar.NewReader(gzipReader)
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Errorf("Error reading tar: %v", err)
-			return err
-		}
-		path := filepath.Join(dest, header.Name)
-		info := header.FileInfo()
-		switch header.Typeflag {
-		case tar.TypeReg:
-			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if err != nil {
-				log.Errorf("Error opening file: %v, %s", err, path)
-				return err
-			}
-			_, err = io.Copy(file, tarReader)
-			if err != nil {
-				log.Errorf("Error copying file: %v", err)
-				return err
-			}
-			err = file.Close()
-			if err != nil {
-				log.Errorf("Error closing file: %v", err)
-				return err
-			}
-		case tar.TypeDir:
-			if err = os.MkdirAll(path, info.Mode()); err != nil {
-				log.Errorf("Error creating directory: %v", err)
-				return err
-			}
-		case tar.TypeSymlink:
-			_, err := os.Stat(path)
-			if err == nil {
-				if err = os.Remove(path); err != nil {
-					log.Errorf("Error removing file: %v", err)
-				}
-			}
-			if err = os.Symlink(header.Linkname, path); err != nil {
-				log.Errorf("Error creating symlink: %v", err)
-				// return err
-			}
-		case tar.TypeXGlobalHeader:
-			log.Debugf("Skipping %s of PAX records: %s", header.Name, header.PAXRecords)
-		case tar.TypeLink:
-			targetPath := header.Linkname
-			linkPath := header.Name
-
-			log.Debugf("Extracting symlink: %s -> %s\n",
-				targetPath, linkPath)
-
-			baseDir := filepath.Dir(linkPath)
-			err := os.MkdirAll(baseDir, 0755)
-			if err != nil {
-				log.Errorf("Error creating directory: %v", err)
-			}
-			_, file, err := HardToSoft(linkPath, targetPath)
-			if err != nil {
-				log.Errorf("Error converting hard link to soft link: %v", err)
-				break
-			}
-			log.Debugf("link: %s -> %s\n", file, linkPath)
-			err = os.Symlink(file, linkPath)
-			if err != nil {
-				log.Errorf("Error creating symlink: %v", err)
-			}
-		default:
-			log.Errorf("Error reading tar: unsupported type: %c in %s", header.Typeflag, header.Name)
-			// return fmt.Errorf("unsupported type: %c in %s", header.Typeflag, header.Name)
-		}
-	}
-	return nil
+	return x.Extract(archiver.TarSource(tar.NewReader(gzipReader)))
 }
 
 func FileIn(filename, tgzName string) bool {
@@ -275,7 +195,61 @@ func FileIn(filename, tgzName string) bool {
 }
 
 func List(tgzName string) ([]string, error) {
-	result := make([]string, 8)
+	return ListContext(context.Background(), tgzName)
+}
+
+// ListContext is List that stops once ctx is done.
+func ListContext(ctx context.Context, tgzName string) ([]string, error) {
+	entries, err := ListEntriesContext(ctx, tgzName)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.Strings(entries), nil
+}
+
+// Entry describes one member of an archive.
+type Entry = archiver.Entry
+
+// ListEntries describes every member of tgzName, including hard links and
+// special files.
+func ListEntries(tgzName string) ([]Entry, error) {
+	return ListEntriesContext(context.Background(), tgzName)
+}
+
+// ListEntriesContext is ListEntries that stops once ctx is done.
+func ListEntriesContext(ctx context.Context, tgzName string) ([]Entry, error) {
+	file, err := os.Open(tgzName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	gzipReader, err := gzip.NewReader(archiver.Reader(ctx, file))
+	if err != nil {
+		log.Errorf("Error reading gzip: %v", err)
+		return nil, err
+	}
+	defer func(gzipReader *gzip.Reader) {
+		err := gzipReader.Close()
+		if err != nil {
+			log.Errorf("Error closing gzip: %v", err)
+		}
+	}(gzipReader)
+	return archiver.ListEntries(ctx, archiver.TarSource(tar.NewReader(gzipReader)))
+}
+
+// FS is a read-only fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS view
+// of an archive. Close it when done.
+type FS = archiver.FS
+
+// OpenFS indexes tgzName for use as an fs.FS. The archive is decompressed
+// once, into a temporary file that FS.Close removes.
+func OpenFS(tgzName string) (*FS, error) {
 	file, err := os.Open(tgzName)
 	if err != nil {
 		log.Errorf("Error opening file: %v", err)
@@ -298,33 +272,69 @@ func List(tgzName string) ([]string, error) {
 			log.Errorf("Error closing gzip: %v", err)
 		}
 	}(gzipReader)
-	tarReader := tar.NewReader(gzipReader)
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Errorf("Error reading tar: %v", err)
-			return nil, err
+	return archiver.NewTarFS(context.Background(), gzipReader)
+}
+
+// Open returns the content of the regular file entry in tgzName, read
+// straight from the archive without extracting anything else. Symlinks and
+// hard links inside the archive are followed.
+func Open(tgzName, entry string) (io.ReadCloser, error) {
+	return archiver.OpenEntry(context.Background(), openSource(tgzName), entry)
+}
+
+// ReadFile returns the content of the regular file entry in tgzName, like Open.
+func ReadFile(tgzName, entry string) ([]byte, error) {
+	return archiver.ReadEntry(context.Background(), openSource(tgzName), entry)
+}
+
+func openSource(tgzName string) archiver.OpenFunc {
+	return func() (archiver.Source, io.Closer, error) {
+		file, err := os.Open(tgzName)
+		if err != nil {
+			log.Errorf("Error opening file: %v", err)
+			return nil, nil, err
 		}
-		switch header.Typeflag {
-		case tar.TypeReg:
-			result = append(result, fmt.Sprintf("File: %s", header.Name))
-		case tar.TypeDir:
-			result = append(result, fmt.Sprintf("Dir: %s", header.Name))
-		case tar.TypeSymlink:
-			result = append(result, fmt.Sprintf("Symlink: %s -> %s", header.Name, header.Linkname))
-		case tar.TypeXGlobalHeader:
-			log.Debugf("Skipping %s of PAX records: %s", header.Name, header.PAXRecords)
-
-		default:
-			log.Errorf("Error reading tar: unsupported type: %c in %s", header.Typeflag, header.Name)
-			return nil, fmt.Errorf("unsupported type: %c in %s", header.Typeflag, header.Name)
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			log.Errorf("Error reading gzip: %v", err)
+			_ = file.Close()
+			return nil, nil, err
 		}
+		closer := archiver.CloserFunc(func() error {
+			_ = gzipReader.Close()
+			return file.Close()
+		})
+		return archiver.TarSource(tar.NewReader(gzipReader)), closer, nil
+	}
+}
+
+// NewWriter returns the gzip stream CompressTo writes its tar to, configured
+// by opts, for writing a tar of entries that don't come from files.
+// Closing it ends the stream but does not close w.
+func NewWriter(w io.Writer, opts ...Option) (io.WriteCloser, error) {
+	return newGzipWriter(w, archiver.NewConfig(opts...))
+}
+
+// newGzipWriter returns the gzip stream CompressTo writes the tar to,
+// deflating on several goroutines when WithConcurrency asks for it.
+func newGzipWriter(w io.Writer, cfg *archiver.Config) (io.WriteCloser, error) {
+	if cfg.Concurrency > 1 {
+		return newParallelWriter(w, cfg.Level, cfg.Concurrency)
+	}
+	gzipWriter, err := gzip.NewWriterLevel(w, cfg.Level)
+	if err != nil {
+		return nil, err
 	}
-	return result, nil
+	// The zero ModTime would be written as a bogus date, not as 0
+	gzipWriter.ModTime = time.Unix(0, 0)
+	return gzipWriter, nil
 }
 
+// HardToSoft returns the base name of link and the path of origin relative
+// to it, for replacing the hard link by a symlink.
+//
+// Deprecated: Compress stores hard links as such and Extract restores them
+// as hard links, or as copies with WithCopyLinks.
 func HardToSoft(link string, origin string) (string, string, error) {
 	// link = ./git_2.47.1_windows_amd64/mingw64/libexec/git-core/Atlassian.Bitbucket.dll
 	// origin = ./git_2.47.1_windows_amd64/mingw64/bin/Atlassian.Bitbucket.dll
@@ -0,0 +1,63 @@
+package tgz
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var errFull = errors.New("disk full")
+
+// fullWriter takes n bytes and fails after that.
+type fullWriter struct {
+	n int
+}
+
+func (w *fullWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errFull
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestCompressToCloseError(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Small enough to sit in the compressor until it is closed
+	for _, n := range []int{0, 20} {
+		if err := CompressTo(&fullWriter{n: n}, src); !errors.Is(err, errFull) {
+			t.Errorf("Test failed for %d bytes, expected: '%v', got:  '%v'", n, errFull, err)
+		}
+	}
+}
+
+func TestCompressAbsoluteFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "file.tgz")
+	if err := Compress(archive, file); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if report, err := Verify(archive); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected a sound archive, got: %+v (%v)", report, err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := Extract(archive, out); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	// Stored without the leading slash, as GNU tar does
+	data, err := os.ReadFile(filepath.Join(out, strings.TrimPrefix(file, filepath.VolumeName(file))))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Test failed, expected: 'hello', got:  '%s' (%v)", data, err)
+	}
+}
@@ -0,0 +1,67 @@
+package tgz
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// Report is what Verify found; Problems is empty for a sound archive.
+type Report = archiver.Report
+
+// Problem is one thing Verify found wrong, with the entry it was found in.
+type Problem = archiver.Problem
+
+// Verify reads all of tgzName without writing anything, checking the tar
+// headers, every entry's content and the CRC and size in the gzip trailer,
+// so truncated or corrupt downloads show before Extract. The error is only
+// for an archive that can't be opened; what is wrong inside is in the report.
+func Verify(tgzName string, opts ...Option) (*Report, error) {
+	return VerifyContext(context.Background(), tgzName, opts...)
+}
+
+// VerifyContext is Verify that stops once ctx is done.
+func VerifyContext(ctx context.Context, tgzName string, opts ...Option) (*Report, error) {
+	file, err := os.Open(tgzName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	meter := archiver.NewConfig(opts...).Meter(-1)
+
+	report := &Report{}
+	gzipReader, err := gzip.NewReader(archiver.Reader(ctx, meter.Reader(file)))
+	if err != nil {
+		report.Add("", err)
+		return report, nil
+	}
+	defer func(gzipReader *gzip.Reader) {
+		_ = gzipReader.Close()
+	}(gzipReader)
+
+	if err := archiver.VerifySource(ctx, archiver.TarSource(tar.NewReader(gzipReader)), meter, report); err != nil {
+		return report, err
+	}
+	if report.OK() {
+		// The tar ends before the gzip stream does; the trailer is checked
+		// when the rest is read
+		if _, err := io.Copy(io.Discard, gzipReader); err != nil {
+			report.Add("", err)
+		}
+	}
+	return report, ctx.Err()
+}
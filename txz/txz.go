@@ -0,0 +1,277 @@
+package txz
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+	"github.com/ulikunitz/xz"
+)
+
+// ErrInsecurePath is returned by Extract for an entry, symlink or hard link
+// that would land outside dest.
+var ErrInsecurePath = archiver.ErrInsecurePath
+
+// ErrDestinationNotEmpty is returned by Extract with WithAtomic when the
+// destination exists and is not an empty directory.
+var ErrDestinationNotEmpty = archiver.ErrDestinationNotEmpty
+
+// Compress creates the tar.xz txzName from files; directories are added
+// with everything below them.
+func Compress(txzName string, files ...string) error {
+	return CompressWithOptions(context.Background(), txzName, files)
+}
+
+// CompressContext is Compress that stops once ctx is done,
+// removing the partially written archive.
+func CompressContext(ctx context.Context, txzName string, files ...string) error {
+	return CompressWithOptions(ctx, txzName, files)
+}
+
+// CompressWithOptions is CompressContext configured by opts.
+func CompressWithOptions(ctx context.Context, txzName string, files []string, opts ...Option) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			if err := os.Remove(txzName); err != nil {
+				log.Errorf("Error removing archive: %v", err)
+			}
+		}
+	}()
+	created, err := os.Create(txzName)
+	if err != nil {
+		log.Errorf("Error creating archive: %v", err)
+		return err
+	}
+	defer func(created *os.File) {
+		err := created.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
+		}
+	}(created)
+	return CompressToWithOptions(ctx, created, files, opts...)
+}
+
+// CompressTo writes a tar.xz of files to w, e.g. an HTTP response or stdout.
+// w is not closed.
+func CompressTo(w io.Writer, files ...string) error {
+	return CompressToWithOptions(context.Background(), w, files)
+}
+
+// CompressToContext is CompressTo that stops once ctx is done.
+func CompressToContext(ctx context.Context, w io.Writer, files ...string) error {
+	return CompressToWithOptions(ctx, w, files)
+}
+
+// CompressToWithOptions is CompressToContext configured by opts.
+func CompressToWithOptions(ctx context.Context, w io.Writer, files []string, opts ...Option) error {
+	cfg := archiver.NewConfig(opts...)
+	total := int64(-1)
+	if cfg.Progress != nil {
+		total = archiver.TotalSize(files)
+	}
+	meter := cfg.Meter(total)
+
+	xzWriter, err := newWriter(w, cfg)
+	if err != nil {
+		log.Errorf("Error creating xz: %v", err)
+		return err
+	}
+	tarWriter := tar.NewWriter(xzWriter)
+	if err := archiver.WriteTar(ctx, tarWriter, files, cfg, meter); err != nil {
+		_ = xzWriter.Close()
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		log.Errorf("Error closing tar: %v", err)
+		_ = xzWriter.Close()
+		return err
+	}
+	if err := xzWriter.Close(); err != nil {
+		log.Errorf("Error closing xz: %v", err)
+		return err
+	}
+	return nil
+}
+
+// NewWriter returns the xz stream CompressTo writes its tar to, for writing
+// a tar of entries that don't come from files. Closing it ends the stream
+// but does not close w.
+func NewWriter(w io.Writer, opts ...Option) (io.WriteCloser, error) {
+	return newWriter(w, archiver.NewConfig(opts...))
+}
+
+// dictCaps are the dictionary sizes of the xz presets -0 to -9.
+var dictCaps = [...]int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// newWriter returns the xz stream CompressTo writes the tar to, with the
+// dictionary size of the level WithLevel asks for.
+func newWriter(w io.Writer, cfg *archiver.Config) (io.WriteCloser, error) {
+	var config xz.WriterConfig
+	if cfg.Level >= 0 && cfg.Level < len(dictCaps) {
+		config.DictCap = dictCaps[cfg.Level]
+	}
+	return config.NewWriter(w)
+}
+
+// Extract extracts the tar.xz name into dest.
+func Extract(name, dest string, opts ...Option) error {
+	return ExtractContext(context.Background(), name, dest, opts...)
+}
+
+// ExtractContext is Extract that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractContext(ctx context.Context, name, dest string, opts ...Option) error {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	return ExtractFromContext(ctx, file, dest, opts...)
+}
+
+// ExtractFrom extracts a tar.xz read from r, e.g. an HTTP body or stdin, into dest.
+func ExtractFrom(r io.Reader, dest string, opts ...Option) error {
+	return ExtractFromContext(context.Background(), r, dest, opts...)
+}
+
+// ExtractFromContext is ExtractFrom that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractFromContext(ctx context.Context, r io.Reader, dest string, opts ...Option) error {
+	x, err := archiver.NewExtractor(ctx, dest, archiver.NewConfig(opts...), -1)
+	if err != nil {
+		return err
+	}
+	defer func(x *archiver.Extractor) {
+		err := x.Close()
+		if err != nil {
+			log.Errorf("Error closing destination: %v", err)
+		}
+	}(x)
+
+	xzReader, err := xz.NewReader(x.Reader(r))
+	if err != nil {
+		log.Errorf("Error reading xz: %v", err)
+		return err
+	}
+	return x.Extract(archiver.TarSource(tar.NewReader(xzReader)))
+}
+
+// FileIn reports whether txzName has an entry whose name ends in filename.
+func FileIn(filename, txzName string) bool {
+	entries, err := ListEntries(txzName)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name, filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// List lists the entries of txzName, directories with a trailing slash.
+func List(txzName string) ([]string, error) {
+	return ListContext(context.Background(), txzName)
+}
+
+// ListContext is List that stops once ctx is done.
+func ListContext(ctx context.Context, txzName string) ([]string, error) {
+	entries, err := ListEntriesContext(ctx, txzName)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.Strings(entries), nil
+}
+
+// Entry describes one member of an archive.
+type Entry = archiver.Entry
+
+// ListEntries describes every member of txzName, including hard links and
+// special files.
+func ListEntries(txzName string) ([]Entry, error) {
+	return ListEntriesContext(context.Background(), txzName)
+}
+
+// ListEntriesContext is ListEntries that stops once ctx is done.
+func ListEntriesContext(ctx context.Context, txzName string) ([]Entry, error) {
+	src, closer, err := openSource(txzName)()
+	if err != nil {
+		return nil, err
+	}
+	defer func(closer io.Closer) {
+		err := closer.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(closer)
+	return archiver.ListEntries(ctx, src)
+}
+
+// FS is a read-only fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS view
+// of an archive. Close it when done.
+type FS = archiver.FS
+
+// OpenFS indexes txzName for use as an fs.FS. The archive is decompressed
+// once, into a temporary file that FS.Close removes.
+func OpenFS(txzName string) (*FS, error) {
+	file, err := os.Open(txzName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	xzReader, err := xz.NewReader(file)
+	if err != nil {
+		log.Errorf("Error reading xz: %v", err)
+		return nil, err
+	}
+	return archiver.NewTarFS(context.Background(), xzReader)
+}
+
+// Open returns the content of the regular file entry in txzName, read
+// straight from the archive without extracting anything else. Symlinks and
+// hard links inside the archive are followed.
+func Open(txzName, entry string) (io.ReadCloser, error) {
+	return archiver.OpenEntry(context.Background(), openSource(txzName), entry)
+}
+
+// ReadFile returns the content of the regular file entry in txzName, like Open.
+func ReadFile(txzName, entry string) ([]byte, error) {
+	return archiver.ReadEntry(context.Background(), openSource(txzName), entry)
+}
+
+func openSource(txzName string) archiver.OpenFunc {
+	return func() (archiver.Source, io.Closer, error) {
+		file, err := os.Open(txzName)
+		if err != nil {
+			log.Errorf("Error opening file: %v", err)
+			return nil, nil, err
+		}
+		xzReader, err := xz.NewReader(file)
+		if err != nil {
+			log.Errorf("Error reading xz: %v", err)
+			_ = file.Close()
+			return nil, nil, err
+		}
+		return archiver.TarSource(tar.NewReader(xzReader)), file, nil
+	}
+}
@@ -0,0 +1,79 @@
+package txz
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTree(t *testing.T, dir string) string {
+	t.Helper()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "tool"), bytes.Repeat([]byte("#!/bin/sh\n"), 100), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "bin", "tool"), filepath.Join(src, "tool")); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestCompress(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTree(t, dir)
+	for _, level := range []int{0, 9} {
+		name := filepath.Join(dir, "src.tar.xz")
+		if err := CompressWithOptions(context.Background(), name, []string{src}, WithLevel(level), WithManifest()); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		expected := []string{"src/", "src/bin/", "src/bin/tool", "src/tool", ".manifest.sha256.json"}
+		entries, err := ListEntries(name)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		if err != nil || !reflect.DeepEqual(names, expected) {
+			t.Errorf("Test failed for level %d, expected: '%v', got:  '%v' (%v)", level, expected, names, err)
+		}
+		if report, err := Verify(name); err != nil || !report.OK() {
+			t.Errorf("Test failed for level %d, expected a sound archive, got: %+v (%v)", level, report, err)
+		}
+		out := filepath.Join(dir, "out", filepath.Base(name))
+		if err := Extract(name, out, WithCheckManifest()); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		a, err := os.Stat(filepath.Join(out, "src", "bin", "tool"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.Stat(filepath.Join(out, "src", "tool")); err != nil || !os.SameFile(a, b) {
+			t.Errorf("Test failed for level %d, expected src/tool to be a hard link of src/bin/tool (%v)", level, err)
+		}
+		if err := os.RemoveAll(filepath.Join(dir, "out")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompressToExtractFrom(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTree(t, dir)
+	var buf bytes.Buffer
+	if err := CompressTo(&buf, src); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := ExtractFrom(&buf, out); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "src", "tool"))
+	if err != nil || len(data) != 1000 {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", 1000, len(data), err)
+	}
+}
@@ -0,0 +1,62 @@
+package txz
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+	"github.com/ulikunitz/xz"
+)
+
+// Report is what Verify found; Problems is empty for a sound archive.
+type Report = archiver.Report
+
+// Problem is one thing Verify found wrong, with the entry it was found in.
+type Problem = archiver.Problem
+
+// Verify reads all of txzName without writing anything, checking the tar
+// headers, every entry's content and the xz block checks and index, so
+// truncated or corrupt downloads show before Extract. The error is only for
+// an archive that can't be opened; what is wrong inside is in the report.
+func Verify(txzName string, opts ...Option) (*Report, error) {
+	return VerifyContext(context.Background(), txzName, opts...)
+}
+
+// VerifyContext is Verify that stops once ctx is done.
+func VerifyContext(ctx context.Context, txzName string, opts ...Option) (*Report, error) {
+	file, err := os.Open(txzName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	meter := archiver.NewConfig(opts...).Meter(-1)
+
+	report := &Report{}
+	xzReader, err := xz.NewReader(archiver.Reader(ctx, meter.Reader(file)))
+	if err != nil {
+		report.Add("", err)
+		return report, nil
+	}
+	if err := archiver.VerifySource(ctx, archiver.TarSource(tar.NewReader(xzReader)), meter, report); err != nil {
+		return report, err
+	}
+	if report.OK() {
+		// The index at the end of the stream is only checked when it is read
+		if _, err := io.Copy(io.Discard, xzReader); err != nil {
+			report.Add("", err)
+		}
+	}
+	return report, ctx.Err()
+}
@@ -2,10 +2,10 @@ package tz
 
 import (
 	"archive/zip"
-	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
 	"io"
 	"io/fs"
 	"os"
@@ -13,8 +13,16 @@ import (
 	"strings"
 )
 
+// ErrInsecurePath is returned by Extract for an entry, symlink or hard link
+// that would land outside dest.
+var ErrInsecurePath = archiver.ErrInsecurePath
+
+// ErrDestinationNotEmpty is returned by Extract with WithAtomic when the
+// destination exists and is not an empty directory.
+var ErrDestinationNotEmpty = archiver.ErrDestinationNotEmpty
+
 func FileIn(filename, zipName string) bool {
-	archive, err := zip.OpenReader(zipName)
+	archive, err := archiver.OpenZip(zipName)
 
 	if err != nil {
 		log.Errorf("Error opening archive: %v", err)
@@ -35,8 +43,15 @@ func FileIn(filename, zipName string) bool {
 	return false
 }
 
-func Extract(name, dest string) error {
-	archive, err := zip.OpenReader(name)
+func Extract(name, dest string, opts ...Option) error {
+	return ExtractContext(context.Background(), name, dest, opts...)
+}
+
+// ExtractContext is Extract that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractContext(ctx context.Context, name, dest string, opts ...Option) error {
+	cfg := archiver.NewConfig(opts...)
+	archive, err := archiver.OpenZip(name)
 	if err != nil {
 		log.Errorf("Error opening archive: %v", err)
 		return err
@@ -47,60 +62,58 @@ func Extract(name, dest string) error {
 			log.Errorf("Error closing archive: %v", err)
 		}
 	}(archive)
-	linkMap := make(map[string]string)
 
-	for _, f := range archive.File {
-		filePath := filepath.Join(dest, f.Name)
-		if f.FileInfo().IsDir() {
-			_ = os.MkdirAll(filePath, os.ModePerm)
-			continue
-		}
-
-		dir := filepath.Dir(filePath)
-		_ = os.MkdirAll(dir, os.ModePerm)
-
-		fileInArchive, err := f.Open()
-		if f.Mode()&fs.ModeSymlink > 0 {
-			buf := new(bytes.Buffer)
-			_, err := io.Copy(buf, fileInArchive)
-			if err != nil {
-				log.Errorf("Error copying file: %v", err)
-				return err
+	total := int64(-1)
+	if cfg.Progress != nil {
+		total = 0
+		for _, f := range archive.File {
+			if !f.Mode().IsRegular() || !cfg.Selected(f.Name) {
+				continue
+			}
+			if _, ok := cfg.MapName(f.Name); ok {
+				total += int64(f.UncompressedSize64)
 			}
-			linkMap[f.Name] = buf.String()
-			continue
-		}
-
-		destFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			log.Errorf("Error opening file: %v", err)
-			return err
-		}
-		if _, err := io.Copy(destFile, fileInArchive); err != nil {
-			log.Errorf("Error copying file: %v", err)
-			return err
 		}
-		_ = destFile.Close()
-		_ = fileInArchive.Close()
 	}
-	wd, err := os.Getwd()
-	err = os.Chdir(dest)
+	x, err := archiver.NewExtractor(ctx, dest, cfg, total)
 	if err != nil {
-		log.Errorf("Error changing directory: %v", err)
 		return err
 	}
-	for k, v := range linkMap {
-		err = os.Symlink(v, k)
+	defer func(x *archiver.Extractor) {
+		err := x.Close()
 		if err != nil {
-			log.Errorf("Error creating symlink: %v", err)
-			return err
+			log.Errorf("Error closing destination: %v", err)
 		}
-	}
-	_ = os.Chdir(wd)
-	return nil
+	}(x)
+	return x.Extract(archiver.ZipSource(archive.File))
 }
 
 func Compress(zipFile string, files ...string) error {
+	return CompressWithOptions(context.Background(), zipFile, files)
+}
+
+// CompressContext is Compress that stops once ctx is done,
+// removing the partially written archive.
+func CompressContext(ctx context.Context, zipFile string, files ...string) error {
+	return CompressWithOptions(ctx, zipFile, files)
+}
+
+// CompressWithOptions is CompressContext configured by opts.
+func CompressWithOptions(ctx context.Context, zipFile string, files []string, opts ...Option) (err error) {
+	cfg := archiver.NewConfig(opts...)
+	total := int64(-1)
+	if cfg.Progress != nil {
+		total = archiver.TotalSize(files)
+	}
+	meter := cfg.Meter(total)
+
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			if err := os.Remove(zipFile); err != nil {
+				log.Errorf("Error removing archive: %v", err)
+			}
+		}
+	}()
 	f, err := os.Create(zipFile)
 	if err != nil {
 		log.Errorf("Error creating file: %v", err)
@@ -112,7 +125,10 @@ func Compress(zipFile string, files ...string) error {
 			log.Errorf("Error closing file: %v", err)
 		}
 	}(f)
-	writer := zip.NewWriter(f)
+	writer, err := archiver.NewZipWriter(f, cfg)
+	if err != nil {
+		return err
+	}
 	defer func(writer *zip.Writer) {
 		err := writer.Close()
 		if err != nil {
@@ -120,20 +136,24 @@ func Compress(zipFile string, files ...string) error {
 		}
 	}(writer)
 
+	manifest := cfg.NewManifest()
 	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		stat, err := os.Stat(file)
 		if err != nil {
 			return err
 		}
 		if stat.IsDir() {
-			err = addDirToZip(writer, file)
+			err = addDirToZip(ctx, cfg, meter, manifest, writer, file)
 			if err != nil {
 				log.Errorf("Error adding dir to zip: %v", err)
 				return err
 			}
 			continue
 		} else if stat.Mode().IsRegular() {
-			err := addFileToZip(writer, file)
+			err := addFileToZip(ctx, cfg, meter, manifest, writer, file)
 			if err != nil {
 				log.Errorf("Error adding file to zip: %v", err)
 				return err
@@ -142,13 +162,38 @@ func Compress(zipFile string, files ...string) error {
 			return errors.New("unsupported file type for " + file)
 		}
 	}
+	if err := manifest.WriteZip(writer, cfg); err != nil {
+		log.Errorf("Error writing manifest: %v", err)
+		return err
+	}
 	return nil
 }
 
 func List(zipFile string) ([]string, error) {
-	result := make([]string, 8)
+	return ListContext(context.Background(), zipFile)
+}
+
+// ListContext is List that stops once ctx is done.
+func ListContext(ctx context.Context, zipFile string) ([]string, error) {
+	entries, err := ListEntriesContext(ctx, zipFile)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.Strings(entries), nil
+}
+
+// Entry describes one member of an archive.
+type Entry = archiver.Entry
+
+// ListEntries describes every member of zipFile, including hard links and
+// special files.
+func ListEntries(zipFile string) ([]Entry, error) {
+	return ListEntriesContext(context.Background(), zipFile)
+}
 
-	archive, err := zip.OpenReader(zipFile)
+// ListEntriesContext is ListEntries that stops once ctx is done.
+func ListEntriesContext(ctx context.Context, zipFile string) ([]Entry, error) {
+	archive, err := archiver.OpenZip(zipFile)
 	if err != nil {
 		log.Errorf("Error opening archive: %v", err)
 		return nil, err
@@ -159,38 +204,67 @@ func List(zipFile string) ([]string, error) {
 			log.Errorf("Error closing archive: %v", err)
 		}
 	}(archive)
+	return archiver.ListEntries(ctx, archiver.ZipSource(archive.File))
+}
 
-	for _, f := range archive.File {
-		info := f.FileInfo()
-		if info.IsDir() {
-			result = append(result, fmt.Sprintf("Dir: %s", f.Name))
-			continue
-		} else if info.Mode().Type() == fs.ModeSymlink {
-			buf := new(bytes.Buffer)
-			reader, err := f.Open()
-			if err != nil {
-				log.Errorf("Error opening Symlink: %v", err)
-				return nil, err
-			}
-			_, err = io.Copy(buf, reader)
-			if err != nil {
-				log.Errorf("Error copying Symlink: %v", err)
-				return nil, err
-			}
-			link := buf.String()
-			result = append(result, fmt.Sprintf("Symlink: %s -> %s", f.Name, link))
-			continue
-		} else if info.Mode().IsRegular() {
-			result = append(result, fmt.Sprintf("File: %s", f.Name))
-			continue
-		} else {
-			return nil, errors.New("unknown file type for " + f.Name + " in zip")
+// FS is a read-only fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS view
+// of an archive. Close it when done.
+type FS = archiver.FS
+
+// OpenFS opens zipFile for use as an fs.FS. Unlike zip.Reader, it follows
+// symlinks and can be served by http.FileServer.
+func OpenFS(zipFile string) (*FS, error) {
+	archive, err := archiver.OpenZip(zipFile)
+	if err != nil {
+		log.Errorf("Error opening archive: %v", err)
+		return nil, err
+	}
+	return archiver.NewZipFS(archive.File, archive)
+}
+
+// Open returns the content of the regular file entry in zipFile.
+// Symlinks inside the archive are followed.
+func Open(zipFile, entry string) (io.ReadCloser, error) {
+	fsys, err := OpenFS(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.OpenFSEntry(fsys, entry)
+}
+
+// ReadFile returns the content of the regular file entry in zipFile, like Open.
+func ReadFile(zipFile, entry string) ([]byte, error) {
+	rc, err := Open(zipFile, entry)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rc io.ReadCloser) {
+		err := rc.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
 		}
+	}(rc)
+	return io.ReadAll(rc)
+}
+
+// Comment returns the archive comment of zipFile. Entry comments are in
+// the Comment of its entries.
+func Comment(zipFile string) (string, error) {
+	archive, err := archiver.OpenZip(zipFile)
+	if err != nil {
+		log.Errorf("Error opening archive: %v", err)
+		return "", err
 	}
-	return result, nil
+	defer func(archive *zip.ReadCloser) {
+		err := archive.Close()
+		if err != nil {
+			log.Errorf("Error closing archive: %v", err)
+		}
+	}(archive)
+	return archive.Comment, nil
 }
 
-func addFileToZip(writer *zip.Writer, file string) error {
+func addFileToZip(ctx context.Context, cfg *archiver.Config, meter *archiver.Meter, manifest *archiver.ManifestBuilder, writer *zip.Writer, file string) error {
 	info, err := os.Stat(file)
 	if err != nil {
 		log.Errorf("Error getting file info: %v", err)
@@ -201,13 +275,23 @@ func addFileToZip(writer *zip.Writer, file string) error {
 		log.Errorf("Error creating header: %v", err)
 		return err
 	}
-	header.Method = zip.Deflate
-	header.Name = file
+	header.Name = archiver.StoredName(file)
+	header.Method = cfg.MethodOf(header.Name)
+	header.Comment = cfg.CommentOf(header.Name)
+	cfg.NormalizeZipHeader(header)
+	if info.Size() >= zip64Threshold {
+		if err := addLargeFile(ctx, cfg, meter, manifest, writer, header, file); err != nil {
+			return err
+		}
+		manifest.Add(header.Name, info.Size(), header.Mode(), header.Modified)
+		return nil
+	}
 	headerWriter, err := writer.CreateHeader(header)
 	if err != nil {
 		log.Errorf("Error creating header: %v", err)
 		return err
 	}
+	meter.Entry(header.Name, info.Size())
 	f, err := os.Open(file)
 	if err != nil {
 		log.Errorf("Error opening file: %v", err)
@@ -219,22 +303,28 @@ func addFileToZip(writer *zip.Writer, file string) error {
 			log.Errorf("Error closing file: %v", err)
 		}
 	}(f)
-	_, err = io.Copy(headerWriter, f)
-	return err
+	_, err = archiver.Copy(ctx, manifest.Writer(meter.Writer(headerWriter)), f)
+	if err != nil {
+		return err
+	}
+	manifest.Add(header.Name, info.Size(), header.Mode(), header.Modified)
+	return nil
 }
 
-func addDirToZip(writer *zip.Writer, dir string) error {
+func addDirToZip(ctx context.Context, cfg *archiver.Config, meter *archiver.Meter, manifest *archiver.ManifestBuilder, writer *zip.Writer, dir string) error {
 	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			log.Errorf("Error walking path: %v", err)
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
 			log.Errorf("Error creating header: %v", err)
 			return err
 		}
-		header.Method = zip.Deflate
 		header.Name, err = filepath.Rel(filepath.Dir(dir), path)
 		if err != nil {
 			log.Errorf("Error getting relative path: %v", err)
@@ -243,11 +333,26 @@ func addDirToZip(writer *zip.Writer, dir string) error {
 		if info.IsDir() {
 			header.Name += "/"
 		}
+		header.Method = cfg.MethodOf(header.Name)
+		header.Comment = cfg.CommentOf(header.Name)
+		cfg.NormalizeZipHeader(header)
+		if info.Mode().IsRegular() && info.Size() >= zip64Threshold {
+			if err := addLargeFile(ctx, cfg, meter, manifest, writer, header, path); err != nil {
+				return err
+			}
+			manifest.Add(header.Name, info.Size(), header.Mode(), header.Modified)
+			return nil
+		}
 		headerWriter, err := writer.CreateHeader(header)
 		if err != nil {
 			log.Errorf("Error creating header: %v", err)
 			return err
 		}
+		if info.Mode().IsRegular() {
+			meter.Entry(header.Name, info.Size())
+		} else {
+			meter.Entry(header.Name, 0)
+		}
 		if info.IsDir() {
 			return nil
 		}
@@ -278,7 +383,11 @@ func addDirToZip(writer *zip.Writer, dir string) error {
 				log.Errorf("Error closing file: %v", err)
 			}
 		}(f)
-		_, err = io.Copy(headerWriter, f)
-		return err
+		_, err = archiver.Copy(ctx, manifest.Writer(meter.Writer(headerWriter)), f)
+		if err != nil {
+			return err
+		}
+		manifest.Add(header.Name, info.Size(), header.Mode(), header.Modified)
+		return nil
 	})
 }
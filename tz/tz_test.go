@@ -0,0 +1,153 @@
+package tz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, dir string) string {
+	t.Helper()
+	src := filepath.Join(dir, "model")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"config.json":       bytes.Repeat([]byte(`{"hidden_size": 4096}`), 100),
+		"model.safetensors": bytes.Repeat([]byte("weights"), 100),
+		"weights.bin":       bytes.Repeat([]byte("weights"), 100),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src
+}
+
+func TestCompressMethods(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTree(t, dir)
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "config.json"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "model.zip")
+	comments := func(name string) string {
+		if name == "model/config.json" {
+			return "from the hub"
+		}
+		return ""
+	}
+	err := CompressWithOptions(context.Background(), name, []string{src},
+		WithMethod(Zstd), WithStore(".bin"), WithComment("model 1.0"), WithEntryComment(comments))
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := map[string]uint16{}
+	for _, f := range archive.File {
+		methods[f.Name] = f.Method
+	}
+	_ = archive.Close()
+	expected := map[string]uint16{
+		"model/":                  Store,
+		"model/config.json":       Zstd,
+		"model/model.safetensors": Store,
+		"model/weights.bin":       Store,
+	}
+	for entry, method := range expected {
+		if methods[entry] != method {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", entry, method, methods[entry])
+		}
+	}
+
+	if comment, err := Comment(name); err != nil || comment != "model 1.0" {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", "model 1.0", comment, err)
+	}
+	entries, err := ListEntries(name)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	for _, entry := range entries {
+		if expected := comments(entry.Name); entry.Comment != expected {
+			t.Errorf("Test failed for %s, expected: '%v', got:  '%v'", entry.Name, expected, entry.Comment)
+		}
+	}
+	if report, err := Verify(name); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected a sound archive, got: %+v (%v)", report, err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := Extract(name, out); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	info, err := os.Stat(filepath.Join(out, "model", "config.json"))
+	if err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", modTime, info, err)
+	}
+}
+
+func TestCompressLargeFiles(t *testing.T) {
+	defer func(threshold int64) {
+		zip64Threshold = threshold
+	}(zip64Threshold)
+	zip64Threshold = 0
+
+	dir := t.TempDir()
+	src := writeTree(t, dir)
+	for _, method := range []uint16{Deflate, Zstd} {
+		name := filepath.Join(dir, "model.zip")
+		if err := CompressWithOptions(context.Background(), name, []string{src}, WithMethod(method), WithManifest()); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if report, err := Verify(name); err != nil || !report.OK() {
+			t.Errorf("Test failed for method %d, expected a sound archive, got: %+v (%v)", method, report, err)
+		}
+		out := filepath.Join(dir, "out", strconv.Itoa(int(method)))
+		if err := Extract(name, out, WithCheckManifest()); err != nil {
+			t.Errorf("Test failed for method %d, expected: '%v', got:  '%v'", method, nil, err)
+		}
+		if unzip, err := exec.LookPath("unzip"); err == nil && method == Deflate {
+			if output, err := exec.Command(unzip, "-t", name).CombinedOutput(); err != nil {
+				t.Errorf("Test failed, expected unzip to accept the archive, got: %v %s", err, output)
+			}
+		}
+	}
+}
+
+func TestCompressAbsoluteFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "file.zip")
+	if err := Compress(archive, file); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if report, err := Verify(archive); err != nil || !report.OK() {
+		t.Errorf("Test failed, expected a sound archive, got: %+v (%v)", report, err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := Extract(archive, out); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	// Stored without the leading slash, as GNU tar does
+	data, err := os.ReadFile(filepath.Join(out, strings.TrimPrefix(file, filepath.VolumeName(file))))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Test failed, expected: 'hello', got:  '%s' (%v)", data, err)
+	}
+}
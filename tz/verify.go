@@ -0,0 +1,56 @@
+package tz
+
+import (
+	"context"
+	"os"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// Report is what Verify found; Problems is empty for a sound archive.
+type Report = archiver.Report
+
+// Problem is one thing Verify found wrong, with the entry it was found in.
+type Problem = archiver.Problem
+
+// Verify reads every entry of zipName without writing anything, checking
+// its name and its content against the CRC-32 and size in the directory,
+// so corrupt downloads show before Extract. Unlike in a tar, one bad entry
+// doesn't stop the rest from being checked. The error is only for an
+// archive that can't be opened; what is wrong inside is in the report.
+func Verify(zipName string, opts ...Option) (*Report, error) {
+	return VerifyContext(context.Background(), zipName, opts...)
+}
+
+// VerifyContext is Verify that stops once ctx is done.
+func VerifyContext(ctx context.Context, zipName string, opts ...Option) (*Report, error) {
+	file, err := os.Open(zipName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		log.Errorf("Error stating file: %v", err)
+		return nil, err
+	}
+	meter := archiver.NewConfig(opts...).Meter(-1)
+
+	report := &Report{}
+	archive, err := archiver.NewZipReader(file, info.Size())
+	if err != nil {
+		report.Add("", err)
+		return report, nil
+	}
+	if err := archiver.VerifyZip(ctx, archive.File, meter, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,126 @@
+package tz
+
+import (
+	"archive/zip"
+	"context"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// zip64Threshold is the size from which files are written by addLargeFile.
+var zip64Threshold int64 = math.MaxUint32
+
+// addLargeFile writes the file at path under header with its CRC and sizes
+// worked out before the local header is written, so that a file of 4 GiB
+// or more gets them in a Zip64 extra field there as well as in the central
+// directory, which readers that stream zips rely on. Compressed content is
+// staged in a temporary file to get there.
+func addLargeFile(ctx context.Context, cfg *archiver.Config, meter *archiver.Meter, manifest *archiver.ManifestBuilder, writer *zip.Writer, header *zip.FileHeader, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(f)
+	meter.Entry(header.Name, int64(header.UncompressedSize64))
+
+	content := f
+	var comp io.WriteCloser = nopWriteCloser{io.Discard}
+	if header.Method != zip.Store {
+		content, err = os.CreateTemp("", "tz-*")
+		if err != nil {
+			log.Errorf("Error creating temporary file: %v", err)
+			return err
+		}
+		defer func(staged *os.File) {
+			_ = staged.Close()
+			if err := os.Remove(staged.Name()); err != nil {
+				log.Errorf("Error removing temporary file: %v", err)
+			}
+		}(content)
+		if comp, err = newCompressor(content, header.Method, cfg); err != nil {
+			log.Errorf("Error creating compressor: %v", err)
+			return err
+		}
+	}
+
+	crc := crc32.NewIEEE()
+	size, err := archiver.Copy(ctx, manifest.Writer(meter.Writer(io.MultiWriter(crc, comp))), f)
+	if err != nil {
+		_ = comp.Close()
+		return err
+	}
+	if err := comp.Close(); err != nil {
+		log.Errorf("Error closing compressor: %v", err)
+		return err
+	}
+	compressed, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking file: %v", err)
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		log.Errorf("Error seeking file: %v", err)
+		return err
+	}
+
+	header.CRC32 = crc.Sum32()
+	header.UncompressedSize64 = uint64(size)
+	header.CompressedSize64 = uint64(compressed)
+	header.Flags &^= 0x8 // no data descriptor, the sizes are in the header
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 20
+	header.ReaderVersion = 20
+	archiver.SetExtendedTime(header)
+	if !isASCII(header.Name) && utf8.ValidString(header.Name) {
+		header.Flags |= 0x800
+	}
+	w, err := writer.CreateRaw(header)
+	if err != nil {
+		log.Errorf("Error creating header: %v", err)
+		return err
+	}
+	_, err = archiver.Copy(ctx, w, io.LimitReader(content, compressed))
+	return err
+}
+
+// newCompressor compresses to w with the zip method.
+func newCompressor(w io.Writer, method uint16, cfg *archiver.Config) (io.WriteCloser, error) {
+	switch method {
+	case zip.Deflate:
+		return flate.NewWriter(w, cfg.Level)
+	case archiver.ZipZstd:
+		return zstd.NewWriter(w, cfg.ZstdEncoder...)
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
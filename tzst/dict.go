@@ -0,0 +1,70 @@
+package tzst
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/gommon/log"
+)
+
+// sampleSize is how much of each file TrainDictionary looks at, and
+// maxSamples how much it looks at in all.
+const (
+	sampleSize = 128 << 10
+	maxSamples = 256 << 20
+)
+
+// TrainDictionary builds a zstd dictionary of up to size bytes from the
+// regular files under files, for WithDictionary. Dictionaries help archives
+// of many small, similar files, such as sources or JSON; train on files like
+// the ones to be compressed, and keep the dictionary to read the archives.
+// size is typically 64 to 112 KiB.
+func TrainDictionary(size int, files ...string) ([]byte, error) {
+	var samples [][]byte
+	total := 0
+	for _, src := range files {
+		err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Errorf("Error walking path: %v", err)
+				return err
+			}
+			if !info.Mode().IsRegular() || info.Size() == 0 || total >= maxSamples {
+				return nil
+			}
+			sample, err := readSample(path)
+			if err != nil {
+				log.Errorf("Error reading file: %v", err)
+				return err
+			}
+			samples = append(samples, sample)
+			total += len(sample)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedBestCompression,
+	})
+}
+
+func readSample(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	return io.ReadAll(io.LimitReader(file, sampleSize))
+}
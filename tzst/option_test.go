@@ -0,0 +1,54 @@
+package tzst
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncoderOptions(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 200 {
+		data := fmt.Sprintf(`{"id": %d, "name": "model-%d", "layers": 32, "hidden_size": 4096, "vocab_size": 32000}`, i, i*7)
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("%03d.json", i)), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dict, err := TrainDictionary(4<<10, src)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	for name, opts := range map[string][]Option{
+		"level":  {WithLevel(SpeedBestCompression), WithConcurrency(2)},
+		"window": {WithLongDistanceMatching(), WithMaxWindowSize(256 << 20)},
+		"dict":   {WithDictionary(dict)},
+	} {
+		archive := filepath.Join(dir, name+".tar.zst")
+		if err := CompressWithOptions(context.Background(), archive, []string{src}, opts...); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		dest := filepath.Join(dir, name)
+		if err := Extract(archive, dest, opts...); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		data, err := ReadFile(archive, "src/042.json", opts...)
+		if err != nil || len(data) == 0 {
+			t.Errorf("Test failed for %s, expected content, got: '%v' (%v)", name, string(data), err)
+		}
+	}
+
+	if _, err := ListEntries(filepath.Join(dir, "dict.tar.zst")); err == nil {
+		t.Errorf("Test failed, expected an error reading without the dictionary")
+	}
+	if err := Extract(filepath.Join(dir, "window.tar.zst"), t.TempDir(), WithMaxWindowSize(1<<20)); err == nil {
+		t.Errorf("Test failed, expected an error for a window over the maximum")
+	}
+}
@@ -0,0 +1,235 @@
+package tzst
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// The zstd seekable format, as in contrib/seekable_format of zstd: independent
+// frames followed by a skippable frame holding the compressed and
+// decompressed size of each. Readers that don't know it skip the table and
+// see an ordinary zstd stream.
+const (
+	seekTableMagic  = 0x184D2A5E
+	seekableMagic   = 0x8F92EAB1
+	seekFooterSize  = 9
+	seekChecksumBit = 0x80
+
+	defaultFrameSize = 4 << 20
+	maxFrameSize     = 1 << 30
+)
+
+var errCorruptSeekTable = errors.New("zstd: corrupt seekable archive")
+
+// seekableWriter compresses every frameSize bytes written to it into a frame
+// of its own, and writes the seek table on Close.
+type seekableWriter struct {
+	w         io.Writer
+	enc       *zstd.Encoder
+	frameSize int
+	buf       []byte
+	dst       []byte
+	table     []byte
+	frames    uint32
+	err       error
+}
+
+func newSeekableWriter(w io.Writer, cfg *archiver.Config) (*seekableWriter, error) {
+	if cfg.FrameSize > maxFrameSize {
+		return nil, fmt.Errorf("zstd: frame size must be at most %d", maxFrameSize)
+	}
+	enc, err := newEncoder(nil, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &seekableWriter{w: w, enc: enc, frameSize: cfg.FrameSize}, nil
+}
+
+func (z *seekableWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && z.err == nil {
+		m := min(len(p), z.frameSize-len(z.buf))
+		z.buf = append(z.buf, p[:m]...)
+		p = p[m:]
+		if len(z.buf) == z.frameSize {
+			z.flush()
+		}
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	return n, nil
+}
+
+func (z *seekableWriter) flush() {
+	if len(z.buf) == 0 || z.err != nil {
+		return
+	}
+	z.dst = z.enc.EncodeAll(z.buf, z.dst[:0])
+	if _, err := z.w.Write(z.dst); err != nil {
+		z.err = err
+		return
+	}
+	z.table = binary.LittleEndian.AppendUint32(z.table, uint32(len(z.dst)))
+	z.table = binary.LittleEndian.AppendUint32(z.table, uint32(len(z.buf)))
+	z.frames++
+	z.buf = z.buf[:0]
+}
+
+// Close writes the last frame and the seek table. It does not close the
+// underlying writer.
+func (z *seekableWriter) Close() error {
+	z.flush()
+	if err := z.enc.Close(); err != nil && z.err == nil {
+		z.err = err
+	}
+	if z.err != nil {
+		return z.err
+	}
+	frame := binary.LittleEndian.AppendUint32(nil, seekTableMagic)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(z.table)+seekFooterSize))
+	frame = append(frame, z.table...)
+	frame = binary.LittleEndian.AppendUint32(frame, z.frames)
+	frame = append(frame, 0)
+	frame = binary.LittleEndian.AppendUint32(frame, seekableMagic)
+	_, z.err = z.w.Write(frame)
+	return z.err
+}
+
+// seekableReader reads the decompressed stream of a seekable archive at
+// random, decompressing only the frames asked for.
+type seekableReader struct {
+	ra  io.ReaderAt
+	dec *zstd.Decoder
+
+	// compressed and decompressed offset of every frame, and of the end
+	comp   []int64
+	decomp []int64
+
+	mu    sync.Mutex
+	frame int
+	buf   []byte
+	src   []byte
+}
+
+// readSeekTable returns the frame offsets of the seek table at the end of
+// ra, or false if there is none.
+func readSeekTable(ra io.ReaderAt, size int64) (comp, decomp []int64, ok bool) {
+	var footer [seekFooterSize]byte
+	if size < 8+seekFooterSize {
+		return nil, nil, false
+	}
+	if _, err := ra.ReadAt(footer[:], size-seekFooterSize); err != nil {
+		return nil, nil, false
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic || footer[4]&^seekChecksumBit != 0 {
+		return nil, nil, false
+	}
+	frames := int64(binary.LittleEndian.Uint32(footer[:4]))
+	entrySize := int64(8)
+	if footer[4]&seekChecksumBit != 0 {
+		entrySize = 12
+	}
+	tableSize := frames*entrySize + seekFooterSize
+	start := size - 8 - tableSize
+	if start < 0 {
+		return nil, nil, false
+	}
+
+	table := make([]byte, 8+tableSize-seekFooterSize)
+	if _, err := ra.ReadAt(table, start); err != nil {
+		return nil, nil, false
+	}
+	if binary.LittleEndian.Uint32(table) != seekTableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, nil, false
+	}
+	comp, decomp = make([]int64, frames+1), make([]int64, frames+1)
+	for i := range frames {
+		entry := table[8+i*entrySize:]
+		comp[i+1] = comp[i] + int64(binary.LittleEndian.Uint32(entry))
+		decomp[i+1] = decomp[i] + int64(binary.LittleEndian.Uint32(entry[4:]))
+	}
+	if comp[frames] != start {
+		return nil, nil, false
+	}
+	return comp, decomp, true
+}
+
+// openSeekable returns a reader of the tar in file if file has a seek table,
+// or nil if it must be read from the start.
+func openSeekable(file *os.File, cfg *archiver.Config) (*seekableReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		log.Errorf("Error stating file: %v", err)
+		return nil, err
+	}
+	comp, decomp, ok := readSeekTable(file, info.Size())
+	if !ok {
+		return nil, nil
+	}
+	dec, err := newDecoder(nil, cfg)
+	if err != nil {
+		log.Errorf("Error creating zstd reader: %v", err)
+		return nil, err
+	}
+	return &seekableReader{ra: file, dec: dec, comp: comp, decomp: decomp, frame: -1}, nil
+}
+
+// Size is the size of the decompressed stream.
+func (r *seekableReader) Size() int64 {
+	return r.decomp[len(r.decomp)-1]
+}
+
+func (r *seekableReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if off >= r.Size() {
+			return n, io.EOF
+		}
+		i := sort.Search(len(r.decomp)-1, func(i int) bool { return r.decomp[i+1] > off })
+		if err := r.load(i); err != nil {
+			return n, err
+		}
+		m := copy(p[n:], r.buf[off-r.decomp[i]:])
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+// load decompresses frame i into buf, unless it is there already.
+func (r *seekableReader) load(i int) error {
+	if r.frame == i {
+		return nil
+	}
+	r.frame = -1
+	r.src = append(r.src[:0], make([]byte, r.comp[i+1]-r.comp[i])...)
+	if _, err := r.ra.ReadAt(r.src, r.comp[i]); err != nil {
+		return err
+	}
+	buf, err := r.dec.DecodeAll(r.src, r.buf[:0])
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) != r.decomp[i+1]-r.decomp[i] {
+		return errCorruptSeekTable
+	}
+	r.buf, r.frame = buf, i
+	return nil
+}
+
+func (r *seekableReader) Close() error {
+	r.dec.Close()
+	return nil
+}
@@ -0,0 +1,89 @@
+package tzst
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSeekable(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "weights"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		data := bytes.Repeat([]byte(fmt.Sprintf("shard %d ", i)), 10000)
+		if err := os.WriteFile(filepath.Join(src, "weights", fmt.Sprintf("%d.bin", i)), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "config.json"), []byte(`{"layers": 2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("weights/0.bin", filepath.Join(src, "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	seekable := filepath.Join(dir, "seekable.tar.zst")
+	plain := filepath.Join(dir, "plain.tar.zst")
+	if err := CompressWithOptions(context.Background(), seekable, []string{src}, WithSeekable(16<<10)); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := CompressWithOptions(context.Background(), plain, []string{src}); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	file, err := os.Open(seekable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, _ := file.Stat()
+	comp, _, ok := readSeekTable(file, info.Size())
+	if !ok || len(comp) < 10 {
+		t.Fatalf("Test failed, expected a seek table with many frames, got: '%v'", len(comp))
+	}
+	plainFile, err := os.Open(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plainFile.Close()
+	plainInfo, _ := plainFile.Stat()
+	if _, _, ok := readSeekTable(plainFile, plainInfo.Size()); ok {
+		t.Errorf("Test failed, expected no seek table in %s", plain)
+	}
+
+	want, err := List(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := List(seekable)
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("Test failed, expected: '%v', got:  '%v' (%v)", want, got, err)
+	}
+
+	for _, name := range []string{seekable, plain} {
+		data, err := ReadFile(name, "src/latest")
+		if err != nil || !bytes.HasPrefix(data, []byte("shard 0 ")) || len(data) != 80000 {
+			t.Errorf("Test failed for %s, expected shard 0, got %d bytes (%v)", name, len(data), err)
+		}
+		if !FileIn("config.json", name) || FileIn("missing.json", name) {
+			t.Errorf("Test failed for %s, FileIn is wrong", name)
+		}
+	}
+
+	// The seek table is a skippable frame, so streaming readers don't see it
+	dest := filepath.Join(dir, "dest")
+	if err := Extract(seekable, dest); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "src", "weights", "4.bin"))
+	if err != nil || len(data) != 80000 {
+		t.Errorf("Test failed, expected: '%v' bytes, got:  '%v' bytes (%v)", 80000, len(data), err)
+	}
+}
@@ -1,20 +1,46 @@
 package tzst
 
 import (
-	"fmt"
+	"context"
 	"sync"
 
 	"archive/tar"
 	"github.com/klauspost/compress/zstd"
 	"github.com/labstack/gommon/log"
-	"github.com/qiuzhanghua/common/util"
+	"github.com/qiuzhanghua/common/internal/archiver"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// ErrInsecurePath is returned by Extract for an entry, symlink or hard link
+// that would land outside dest.
+var ErrInsecurePath = archiver.ErrInsecurePath
+
+// ErrDestinationNotEmpty is returned by Extract with WithAtomic when the
+// destination exists and is not an empty directory.
+var ErrDestinationNotEmpty = archiver.ErrDestinationNotEmpty
+
 func Compress(tarZstName string, files ...string) error {
+	return CompressWithOptions(context.Background(), tarZstName, files)
+}
+
+// CompressContext is Compress that stops once ctx is done,
+// removing the partially written archive.
+func CompressContext(ctx context.Context, tarZstName string, files ...string) error {
+	return CompressWithOptions(ctx, tarZstName, files)
+}
+
+// CompressWithOptions is CompressContext configured by opts.
+func CompressWithOptions(ctx context.Context, tarZstName string, files []string, opts ...Option) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			if err := os.Remove(tarZstName); err != nil {
+				log.Errorf("Error removing archive: %v", err)
+			}
+		}
+	}()
 	created, err := os.Create(tarZstName)
 	if err != nil {
 		log.Errorf("Error creating archive: %v", err)
@@ -26,127 +52,60 @@ func Compress(tarZstName string, files ...string) error {
 			log.Errorf("Error closing archive: %v", err)
 		}
 	}(created)
+	return CompressToWithOptions(ctx, created, files, opts...)
+}
+
+// CompressTo writes a tar.zst of files to w, e.g. an HTTP response or stdout.
+// w is not closed.
+func CompressTo(w io.Writer, files ...string) error {
+	return CompressToWithOptions(context.Background(), w, files)
+}
+
+// CompressToContext is CompressTo that stops once ctx is done.
+func CompressToContext(ctx context.Context, w io.Writer, files ...string) error {
+	return CompressToWithOptions(ctx, w, files)
+}
+
+// CompressToWithOptions is CompressToContext configured by opts.
+func CompressToWithOptions(ctx context.Context, w io.Writer, files []string, opts ...Option) error {
+	cfg := archiver.NewConfig(opts...)
+	total := int64(-1)
+	if cfg.Progress != nil {
+		total = archiver.TotalSize(files)
+	}
+	meter := cfg.Meter(total)
 
 	// Create Zstandard writer
-	zstdWriter, err := zstd.NewWriter(created)
+	zstdWriter, err := newWriter(w, cfg)
 	if err != nil {
 		log.Errorf("Error creating zstd writer: %v", err)
 		return err
 	}
-	defer func(zstdWriter *zstd.Encoder) {
-		err := zstdWriter.Close()
-		if err != nil {
-			log.Errorf("Error closing zstd: %v", err)
-		}
-	}(zstdWriter)
 
 	tarWriter := tar.NewWriter(zstdWriter)
-	defer func(tarWriter *tar.Writer) {
-		err := tarWriter.Close()
-		if err != nil {
-			log.Errorf("Error closing tar: %v", err)
-		}
-	}(tarWriter)
-
-	for _, src := range files {
-		info, err := os.Stat(src)
-		if err != nil {
-			log.Errorf("Error stating files: %v", err)
-			return err
-		}
-		var baseDir string
-		if info.IsDir() {
-			baseDir = filepath.Base(src)
-		}
-
-		err = filepath.Walk(src,
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					log.Errorf("Error walking path: %v", err)
-					return err
-				}
-
-				header, err := tar.FileInfoHeader(info, "")
-				if err != nil {
-					log.Errorf("Error creating tar header: %v", err)
-					return err
-				}
-
-				// Set header name
-				header.Name = path
-				if baseDir != "" {
-					header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, src))
-				}
-
-				// Clean up header name for cross-platform compatibility
-				header.Name = filepath.ToSlash(header.Name)
-
-				// Handle symlinks
-				if info.Mode()&os.ModeSymlink != 0 {
-					link, err := os.Readlink(path)
-					if err != nil {
-						log.Errorf("Error reading symlink: %v", err)
-						return err
-					}
-					header.Linkname = link
-				}
-
-				if err := tarWriter.WriteHeader(header); err != nil {
-					log.Errorf("Error writing header: %v", err)
-					return err
-				}
-
-				// Don't write file content for directories or symlinks
-				if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
-					return nil
-				}
-
-				// Write regular file content
-				file, err := os.Open(path)
-				if err != nil {
-					log.Errorf("Error opening file: %v", err)
-					return err
-				}
-				defer func(file *os.File) {
-					err := file.Close()
-					if err != nil {
-						log.Errorf("Error closing file: %v", err)
-					}
-				}(file)
-
-				_, err = io.Copy(tarWriter, file)
-				if err != nil {
-					log.Errorf("Error copying file data: %v %s", err, path)
-					return err
-				}
-				return nil
-			})
-		if err != nil {
-			return err
-		}
-	}
-
-	// Ensure all data is flushed
-	if err := tarWriter.Flush(); err != nil {
-		log.Errorf("Error flushing tar writer: %v", err)
+	if err := archiver.WriteTar(ctx, tarWriter, files, cfg, meter); err != nil {
+		_ = zstdWriter.Close()
 		return err
 	}
-
-	return nil
-}
-
-func Extract(name, dest string) error {
-	dest, err := util.ExpandHome(dest)
-	if err != nil {
-		log.Errorf("Error expanding home dir: %v", err)
+	if err := tarWriter.Close(); err != nil {
+		log.Errorf("Error closing tar: %v", err)
+		_ = zstdWriter.Close()
 		return err
 	}
-	dest, err = util.AbsPath(dest)
-	if err != nil {
-		log.Errorf("Error getting absolute path: %v", err)
+	if err := zstdWriter.Close(); err != nil {
+		log.Errorf("Error closing zstd: %v", err)
 		return err
 	}
+	return nil
+}
+
+func Extract(name, dest string, opts ...Option) error {
+	return ExtractContext(context.Background(), name, dest, opts...)
+}
 
+// ExtractContext is Extract that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractContext(ctx context.Context, name, dest string, opts ...Option) error {
 	file, err := os.Open(name)
 	if err != nil {
 		log.Errorf("Error opening file: %v", err)
@@ -158,155 +117,46 @@ func Extract(name, dest string) error {
 			log.Errorf("Error closing file: %v", err)
 		}
 	}(file)
+	if info, err := file.Stat(); err == nil {
+		opts = append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)
+	}
+	return ExtractFromContext(ctx, file, dest, opts...)
+}
 
-	// Create Zstandard reader
-	zstdReader, err := zstd.NewReader(file)
+// ExtractFrom extracts a tar.zst read from r, e.g. an HTTP body or stdin, into dest.
+func ExtractFrom(r io.Reader, dest string, opts ...Option) error {
+	return ExtractFromContext(context.Background(), r, dest, opts...)
+}
+
+// ExtractFromContext is ExtractFrom that stops once ctx is done,
+// removing whatever it had created under dest.
+func ExtractFromContext(ctx context.Context, r io.Reader, dest string, opts ...Option) error {
+	cfg := archiver.NewConfig(opts...)
+	x, err := archiver.NewExtractor(ctx, dest, cfg, -1)
 	if err != nil {
-		log.Errorf("Error creating zstd reader: %v", err)
 		return err
 	}
-	defer zstdReader.Close()
-
-	tarReader := tar.NewReader(zstdReader)
-
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Errorf("Error reading tar: %v", err)
-			return err
-		}
-
-		// Clean and secure the target path
-		targetPath := filepath.Join(dest, header.Name)
-		targetPath = filepath.Clean(targetPath)
-
-		// Security check: ensure the target path is within destination directory
-		if !strings.HasPrefix(targetPath, filepath.Clean(dest)+string(os.PathSeparator)) &&
-			targetPath != filepath.Clean(dest) {
-			log.Errorf("Security violation: trying to write outside destination directory: %s", header.Name)
-			return fmt.Errorf("security violation: path traversal attempt")
+	defer func(x *archiver.Extractor) {
+		err := x.Close()
+		if err != nil {
+			log.Errorf("Error closing destination: %v", err)
 		}
+	}(x)
 
-		info := header.FileInfo()
-
-		switch header.Typeflag {
-		case tar.TypeReg:
-			// Ensure parent directory exists
-			parentDir := filepath.Dir(targetPath)
-			if err := os.MkdirAll(parentDir, 0755); err != nil {
-				log.Errorf("Error creating parent directory: %v", err)
-				return err
-			}
-
-			// Create the file
-			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
-			if err != nil {
-				log.Errorf("Error opening file: %v, %s", err, targetPath)
-				return err
-			}
-
-			// Copy file content
-			_, err = io.Copy(file, tarReader)
-			if err != nil {
-				file.Close()
-				log.Errorf("Error copying file: %v", err)
-				return err
-			}
-
-			// Set file modification time
-			if err := os.Chtimes(targetPath, header.AccessTime, header.ModTime); err != nil {
-				log.Warnf("Could not set file times: %v", err)
-			}
-
-			err = file.Close()
-			if err != nil {
-				log.Errorf("Error closing file: %v", err)
-				return err
-			}
-
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, info.Mode().Perm()); err != nil {
-				log.Errorf("Error creating directory: %v", err)
-				return err
-			}
-
-			// Set directory modification time
-			if err := os.Chtimes(targetPath, header.AccessTime, header.ModTime); err != nil {
-				log.Warnf("Could not set directory times: %v", err)
-			}
-
-		case tar.TypeSymlink:
-			// Ensure parent directory exists
-			parentDir := filepath.Dir(targetPath)
-			if err := os.MkdirAll(parentDir, 0755); err != nil {
-				log.Errorf("Error creating parent directory: %v", err)
-				return err
-			}
-
-			// Remove existing file/symlink
-			if _, err := os.Lstat(targetPath); err == nil {
-				if err := os.Remove(targetPath); err != nil {
-					log.Errorf("Error removing existing file: %v", err)
-					return err
-				}
-			}
-
-			// Create the symlink
-			if err := os.Symlink(header.Linkname, targetPath); err != nil {
-				log.Errorf("Error creating symlink: %v", err)
-				// Continue extracting other files
-			}
-
-		case tar.TypeLink:
-			// Hard link handling
-			targetLinkPath := filepath.Join(dest, header.Linkname)
-			targetLinkPath = filepath.Clean(targetLinkPath)
-
-			// Security check for hard link target
-			if !strings.HasPrefix(targetLinkPath, filepath.Clean(dest)+string(os.PathSeparator)) &&
-				targetLinkPath != filepath.Clean(dest) {
-				log.Errorf("Security violation: hard link points outside destination: %s", header.Linkname)
-				break // Skip this entry but continue extraction
-			}
-
-			// Ensure parent directory exists
-			parentDir := filepath.Dir(targetPath)
-			if err := os.MkdirAll(parentDir, 0755); err != nil {
-				log.Errorf("Error creating directory: %v", err)
-				break
-			}
-
-			// Check if target exists (hard link source must exist)
-			if _, err := os.Stat(targetLinkPath); os.IsNotExist(err) {
-				log.Errorf("Hard link target does not exist: %s", header.Linkname)
-				break
-			}
-
-			// Create hard link
-			if err := os.Link(targetLinkPath, targetPath); err != nil {
-				log.Errorf("Error creating hard link: %v", err)
-			}
-
-		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
-			// Special files - usually skipped in most implementations
-			log.Debugf("Skipping special file: %s (type: %c)", header.Name, header.Typeflag)
-
-		case tar.TypeXGlobalHeader, tar.TypeXHeader:
-			// PAX extended headers - skip but preserve information
-			log.Debugf("Skipping PAX header: %s", header.Name)
-
-		default:
-			log.Errorf("Unsupported tar entry type: %c in %s", header.Typeflag, header.Name)
-			// Skip unsupported types but continue extraction
-		}
+	// Create Zstandard reader
+	zstdReader, err := newDecoder(x.Reader(r), cfg)
+	if err != nil {
+		log.Errorf("Error creating zstd reader: %v", err)
+		return err
 	}
+	defer zstdReader.Close()
 
-	return nil
+	return x.Extract(archiver.TarSource(tar.NewReader(zstdReader)))
 }
 
-func FileIn(filename, tarZstName string) bool {
+// FileIn reports whether tarZstName has an entry matching filename.
+// opts may carry decoder settings such as WithDictionary.
+func FileIn(filename, tarZstName string, opts ...Option) bool {
 	file, err := os.Open(tarZstName)
 	if err != nil {
 		log.Errorf("Error opening file: %v", err)
@@ -319,15 +169,11 @@ func FileIn(filename, tarZstName string) bool {
 		}
 	}(file)
 
-	// Create Zstandard reader
-	zstdReader, err := zstd.NewReader(file)
+	tarReader, closer, err := newTarReader(context.Background(), file, archiver.NewConfig(opts...))
 	if err != nil {
-		log.Errorf("Error creating zstd reader: %v", err)
 		return false
 	}
-	defer zstdReader.Close()
-
-	tarReader := tar.NewReader(zstdReader)
+	defer closer.Close()
 
 	// Clean and prepare the filename for comparison
 	searchFilename := filepath.Clean(filename)
@@ -377,7 +223,62 @@ func FileIn(filename, tarZstName string) bool {
 	return false
 }
 
-func List(tarZstName string) ([]string, error) {
+func List(tarZstName string, opts ...Option) ([]string, error) {
+	return ListContext(context.Background(), tarZstName, opts...)
+}
+
+// ListContext is List that stops once ctx is done.
+func ListContext(ctx context.Context, tarZstName string, opts ...Option) ([]string, error) {
+	entries, err := ListEntriesContext(ctx, tarZstName, opts...)
+	return archiver.Strings(entries), err // Return partial results on error
+}
+
+// Entry describes one member of an archive.
+type Entry = archiver.Entry
+
+// ListEntries describes every member of tarZstName, including hard links and
+// special files.
+func ListEntries(tarZstName string, opts ...Option) ([]Entry, error) {
+	return ListEntriesContext(context.Background(), tarZstName, opts...)
+}
+
+// ListEntriesContext is ListEntries that stops once ctx is done.
+func ListEntriesContext(ctx context.Context, tarZstName string, opts ...Option) ([]Entry, error) {
+	file, err := os.Open(tarZstName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+
+	tarReader, closer, err := newTarReader(ctx, file, archiver.NewConfig(opts...))
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	return archiver.ListEntries(ctx, archiver.TarSource(tarReader))
+}
+
+// FS is a read-only fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS view
+// of an archive. Close it when done.
+type FS = archiver.FS
+
+// OpenFS indexes tarZstName for use as an fs.FS. A seekable archive is read
+// in place through its seek table; any other is decompressed once, into a
+// temporary file that FS.Close removes.
+func OpenFS(tarZstName string, opts ...Option) (*FS, error) {
+	cfg := archiver.NewConfig(opts...)
+	fsys, err := openSeekableFS(tarZstName, cfg)
+	if err != nil || fsys != nil {
+		return fsys, err
+	}
+
 	file, err := os.Open(tarZstName)
 	if err != nil {
 		log.Errorf("Error opening file: %v", err)
@@ -391,41 +292,146 @@ func List(tarZstName string) ([]string, error) {
 	}(file)
 
 	// Create Zstandard reader
-	zstdReader, err := zstd.NewReader(file)
+	zstdReader, err := newDecoder(file, cfg)
 	if err != nil {
 		log.Errorf("Error creating zstd reader: %v", err)
 		return nil, err
 	}
 	defer zstdReader.Close()
 
-	tarReader := tar.NewReader(zstdReader)
-	result := make([]string, 0, 8) // Initialize with 0 length, capacity 8
+	return archiver.NewTarFS(context.Background(), zstdReader)
+}
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Errorf("Error reading tar: %v", err)
-			return result, err // Return partial results on error
-		}
+// openSeekableFS indexes tarZstName through its seek table, or returns nil
+// if it has none.
+func openSeekableFS(tarZstName string, cfg *archiver.Config) (*FS, error) {
+	file, err := os.Open(tarZstName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	sr, err := openSeekable(file, cfg)
+	if err != nil || sr == nil {
+		_ = file.Close()
+		return nil, err
+	}
+	closer := archiver.CloserFunc(func() error {
+		_ = sr.Close()
+		return file.Close()
+	})
+	return archiver.NewTarFSAt(context.Background(), sr, sr.Size(), closer)
+}
 
-		switch header.Typeflag {
-		case tar.TypeReg:
-			result = append(result, fmt.Sprintf("File: %s", header.Name))
-		case tar.TypeDir:
-			result = append(result, fmt.Sprintf("Dir: %s", header.Name))
-		case tar.TypeSymlink:
-			result = append(result, fmt.Sprintf("Symlink: %s -> %s", header.Name, header.Linkname))
-		case tar.TypeXGlobalHeader:
-			log.Debugf("Skipping %s of PAX records: %s", header.Name, header.PAXRecords)
-
-		default:
-			log.Errorf("Error reading tar: unsupported type: %c in %s", header.Typeflag, header.Name)
-			return nil, fmt.Errorf("unsupported type: %c in %s", header.Typeflag, header.Name)
+// Open returns the content of the regular file entry in tarZstName, read
+// straight from the archive without extracting anything else. Symlinks and
+// hard links inside the archive are followed. In a seekable archive only the
+// frames holding the headers and the entry are decompressed.
+func Open(tarZstName, entry string, opts ...Option) (io.ReadCloser, error) {
+	fsys, err := openSeekableFS(tarZstName, archiver.NewConfig(opts...))
+	if err != nil {
+		return nil, err
+	}
+	if fsys != nil {
+		return archiver.OpenFSEntry(fsys, entry)
+	}
+	return archiver.OpenEntry(context.Background(), openSource(tarZstName, opts), entry)
+}
+
+// ReadFile returns the content of the regular file entry in tarZstName, like Open.
+func ReadFile(tarZstName, entry string, opts ...Option) ([]byte, error) {
+	fsys, err := openSeekableFS(tarZstName, archiver.NewConfig(opts...))
+	if err != nil {
+		return nil, err
+	}
+	if fsys != nil {
+		defer func(fsys *FS) {
+			err := fsys.Close()
+			if err != nil {
+				log.Errorf("Error closing archive: %v", err)
+			}
+		}(fsys)
+		return fsys.ReadFile(entry)
+	}
+	return archiver.ReadEntry(context.Background(), openSource(tarZstName, opts), entry)
+}
+
+func openSource(tarZstName string, opts []Option) archiver.OpenFunc {
+	cfg := archiver.NewConfig(opts...)
+	return func() (archiver.Source, io.Closer, error) {
+		file, err := os.Open(tarZstName)
+		if err != nil {
+			log.Errorf("Error opening file: %v", err)
+			return nil, nil, err
 		}
+		zstdReader, err := newDecoder(file, cfg)
+		if err != nil {
+			log.Errorf("Error creating zstd reader: %v", err)
+			_ = file.Close()
+			return nil, nil, err
+		}
+		closer := archiver.CloserFunc(func() error {
+			zstdReader.Close()
+			return file.Close()
+		})
+		return archiver.TarSource(tar.NewReader(zstdReader)), closer, nil
+	}
+}
+
+// NewWriter returns the zstd stream CompressTo writes its tar to, configured
+// by opts, for writing a tar of entries that don't come from files.
+// Closing it ends the stream but does not close w.
+func NewWriter(w io.Writer, opts ...Option) (io.WriteCloser, error) {
+	return newWriter(w, archiver.NewConfig(opts...))
+}
+
+// newWriter returns the stream Compress writes the tar to, in the seekable
+// format if WithSeekable asks for it.
+func newWriter(w io.Writer, cfg *archiver.Config) (io.WriteCloser, error) {
+	if cfg.FrameSize > 0 {
+		return newSeekableWriter(w, cfg)
+	}
+	return newEncoder(w, cfg)
+}
+
+// newTarReader reads the tar in file, through the seek table if there is
+// one, so that file content is skipped rather than decompressed.
+func newTarReader(ctx context.Context, file *os.File, cfg *archiver.Config) (*tar.Reader, io.Closer, error) {
+	sr, err := openSeekable(file, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sr != nil {
+		return tar.NewReader(io.NewSectionReader(sr, 0, sr.Size())), sr, nil
+	}
+
+	// Create Zstandard reader
+	zstdReader, err := newDecoder(archiver.Reader(ctx, file), cfg)
+	if err != nil {
+		log.Errorf("Error creating zstd reader: %v", err)
+		return nil, nil, err
+	}
+	return tar.NewReader(zstdReader), archiver.CloserFunc(func() error {
+		zstdReader.Close()
+		return nil
+	}), nil
+}
+
+// newEncoder returns the zstd stream Compress writes the tar to.
+func newEncoder(w io.Writer, cfg *archiver.Config) (*zstd.Encoder, error) {
+	var opts []zstd.EOption
+	if cfg.Concurrency > 0 {
+		opts = append(opts, zstd.WithEncoderConcurrency(cfg.Concurrency))
+	}
+	return zstd.NewWriter(w, append(opts, cfg.ZstdEncoder...)...)
+}
+
+// newDecoder returns the zstd stream Extract and friends read the tar from.
+func newDecoder(r io.Reader, cfg *archiver.Config) (*zstd.Decoder, error) {
+	var opts []zstd.DOption
+	if cfg.Concurrency > 0 {
+		opts = append(opts, zstd.WithDecoderConcurrency(cfg.Concurrency))
 	}
-	return result, nil
+	return zstd.NewReader(r, append(opts, cfg.ZstdDecoder...)...)
 }
 
 var zstdEncoderPool = sync.Pool{
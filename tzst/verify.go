@@ -0,0 +1,86 @@
+package tzst
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/labstack/gommon/log"
+	"github.com/qiuzhanghua/common/internal/archiver"
+)
+
+// Report is what Verify found; Problems is empty for a sound archive.
+type Report = archiver.Report
+
+// Problem is one thing Verify found wrong, with the entry it was found in.
+type Problem = archiver.Problem
+
+// Verify reads all of tarZstName without writing anything, checking the tar
+// headers, every entry's content, the checksum of every zstd frame that has
+// one, and the seek table of a seekable archive, so truncated or corrupt
+// downloads show before Extract. The error is only for an archive that can't
+// be opened; what is wrong inside is in the report.
+func Verify(tarZstName string, opts ...Option) (*Report, error) {
+	return VerifyContext(context.Background(), tarZstName, opts...)
+}
+
+// VerifyContext is Verify that stops once ctx is done.
+func VerifyContext(ctx context.Context, tarZstName string, opts ...Option) (*Report, error) {
+	file, err := os.Open(tarZstName)
+	if err != nil {
+		log.Errorf("Error opening file: %v", err)
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Errorf("Error closing file: %v", err)
+		}
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		log.Errorf("Error stating file: %v", err)
+		return nil, err
+	}
+	cfg := archiver.NewConfig(append([]Option{archiver.WithArchiveSize(info.Size())}, opts...)...)
+	meter := cfg.Meter(-1)
+
+	report := &Report{}
+	zstdReader, err := newDecoder(archiver.Reader(ctx, meter.Reader(file)), cfg)
+	if err != nil {
+		report.Add("", err)
+		return report, nil
+	}
+	defer zstdReader.Close()
+	counter := &countingReader{r: zstdReader}
+
+	if err := archiver.VerifySource(ctx, archiver.TarSource(tar.NewReader(counter)), meter, report); err != nil {
+		return report, err
+	}
+	if !report.OK() {
+		return report, nil
+	}
+	// Frame checksums are checked as frames end, so read up to the last one
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		report.Add("", err)
+		return report, ctx.Err()
+	}
+	if _, decomp, ok := readSeekTable(file, info.Size()); ok && decomp[len(decomp)-1] != counter.n {
+		report.Add("", fmt.Errorf("%w: seek table has %d bytes, frames have %d",
+			errCorruptSeekTable, decomp[len(decomp)-1], counter.n))
+	}
+	return report, ctx.Err()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}